@@ -7,11 +7,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -45,16 +48,12 @@ func signalContext() (context.Context, context.CancelFunc) {
 	return ctx, cancel
 }
 
-func run(cfg *config.Config) error {
-	log.SetLevel(cfg.LogLevel)
-	ctx, cancel := signalContext()
-	defer cancel()
-
-	models, err := meta.Fetch[meta.Collections](cfg.Models.Models)
-	if err != nil {
-		return fmt.Errorf("loading models failed: %w", err)
-	}
-
+// loadSearchModels cuts the models down to the searched ones.
+// Returns them with the map of collections contained in each other.
+func loadSearchModels(
+	cfg *config.Config,
+	models meta.Collections,
+) (meta.Collections, map[string]map[string]struct{}, error) {
 	// For text indexing we can only use string fields.
 	searchModels := models.Clone()
 	containmentMap := map[string]map[string]struct{}{}
@@ -63,13 +62,114 @@ func run(cfg *config.Config) error {
 	if cfg.Models.Search != "" {
 		searchFilter, err := meta.Fetch[meta.Filters](cfg.Models.Search)
 		if err != nil {
-			return fmt.Errorf("loading search filters failed. %w", err)
+			return nil, nil, fmt.Errorf("loading search filters failed. %w", err)
 		}
 		containmentMap = searchFilter.ContainmentMap()
 		searchModels.Retain(searchFilter.Retain(false))
 	} else {
 		searchModels.Retain(meta.RetainStrings())
 	}
+	return searchModels, containmentMap, nil
+}
+
+// searchModTime returns the modification time of a local search file.
+func searchModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		log.Debugf("checking search file failed: %v\n", err)
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// watchSearchModels reloads the search models if the search file
+// changes or SIGHUP is received.
+func watchSearchModels(
+	ctx context.Context,
+	cfg *config.Config,
+	models meta.Collections,
+	qs *search.QueryServer,
+	webModels *web.Models,
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, unix.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Remote files are only reloaded on SIGHUP.
+	path := cfg.Models.Search
+	watch := cfg.Models.SearchWatch > 0 && path != "" &&
+		!strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://")
+
+	// The ticker is needed anyway to retry a reload.
+	interval := cfg.Models.SearchWatch
+	if interval <= 0 {
+		interval = config.DefaultSearchWatch
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	if watch {
+		modTime = searchModTime(path)
+	}
+
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("reloading search models on SIGHUP")
+			pending = true
+		case <-ticker.C:
+			if watch {
+				if t := searchModTime(path); !t.Equal(modTime) {
+					log.Infof("%s changed, reloading search models\n", path)
+					modTime = t
+					pending = true
+				}
+			}
+		}
+		if !pending {
+			continue
+		}
+
+		searchModels, containmentMap, err := loadSearchModels(cfg, models)
+		if err != nil {
+			log.Errorf("reloading search models failed: %v\n", err)
+			pending = false
+			continue
+		}
+		err = qs.Reload(searchModels, func() {
+			webModels.Set(searchModels.CollectionRequestFields(), containmentMap)
+			log.Info("search models reloaded")
+		})
+		switch {
+		case errors.Is(err, search.ErrRebuildRunning), errors.Is(err, search.ErrIndexBuilding):
+			log.Debugf("reloading search models postponed: %v\n", err)
+		case err != nil:
+			log.Errorf("reloading search models failed: %v\n", err)
+			pending = false
+		default:
+			pending = false
+		}
+	}
+}
+
+func run(cfg *config.Config) error {
+	log.SetLevel(cfg.LogLevel)
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	models, err := meta.Fetch[meta.Collections](cfg.Models.Models)
+	if err != nil {
+		return fmt.Errorf("loading models failed: %w", err)
+	}
+
+	searchModels, containmentMap, err := loadSearchModels(cfg, models)
+	if err != nil {
+		return err
+	}
 
 	db := search.NewDatabase(cfg)
 	ti, err := search.NewTextIndex(cfg, db, searchModels)
@@ -78,13 +178,23 @@ func run(cfg *config.Config) error {
 	}
 	defer ti.Close()
 
-	runtime.GC()
-
 	qs, err := search.NewQueryServer(cfg, ti)
 	if err != nil {
 		return err
 	}
-	go qs.Run(ctx)
+
+	// The web server is started while the index is built,
+	// so its state can be reported.
+	indexErr := make(chan error, 1)
+	go func() {
+		if err := ti.Open(); err != nil {
+			indexErr <- fmt.Errorf("creating text index failed: %w", err)
+			cancel()
+			return
+		}
+		runtime.GC()
+		qs.Run(ctx)
+	}()
 
 	lookup := new(environment.ForProduction)
 	// Redis as message bus for datastore and logout events.
@@ -97,12 +207,36 @@ func run(cfg *config.Config) error {
 
 	go authBackground(ctx, oserror.Handle)
 
-	return web.Run(ctx, cfg, authService, qs, searchModels.CollectionRequestFields(), containmentMap)
+	webModels := web.NewModels(searchModels.CollectionRequestFields(), containmentMap)
+	go watchSearchModels(ctx, cfg, models, qs, webModels)
+
+	err = web.Run(ctx, cfg, authService, qs, webModels)
+	select {
+	case err := <-indexErr:
+		return err
+	default:
+		return err
+	}
 }
 
 func main() {
+	notifyTrigger := flag.Bool("notify-trigger", false,
+		"print the SQL to install the trigger for SEARCH_INDEX_NOTIFY_CHANNEL and exit")
+	updatedIndex := flag.Bool("updated-index", false,
+		"print the SQL to create the index used by updates and exit")
 	flag.Parse()
+	if *updatedIndex {
+		fmt.Print(search.UpdatedIndexSQL)
+		return
+	}
 	cfg, err := config.GetConfig()
 	check(err)
+	if *notifyTrigger {
+		if cfg.Index.NotifyChannel == "" {
+			log.Fatalln("error: SEARCH_INDEX_NOTIFY_CHANNEL is not set")
+		}
+		fmt.Print(search.NotifyTriggerSQL(cfg.Index.NotifyChannel))
+		return
+	}
 	check(run(cfg))
 }
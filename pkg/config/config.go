@@ -18,13 +18,21 @@ import (
 const (
 	DefaultWebPort        = 9050
 	DefaultWebHost        = ""
+	DefaultMetricsPort    = 9051
 	DefaultMaxQueue       = 5
+	DefaultWorkers        = 4
+	DefaultQueryTimeout   = 10 * time.Second
+	DefaultCacheSize      = 256
 	DefaultIndexAge       = 100 * time.Millisecond
 	DefaultIndexFile      = "search.bleve"
 	DefaultIndexUpdate    = 2 * time.Minute
+	DefaultIndexSweep     = 30 * time.Minute
 	DefaultIndexBatch     = 4096
 	DefaultModels         = "models.yml"
 	DefaultSearch         = "search.yml"
+	DefaultSearchWatch    = 10 * time.Second
+	DefaultFuzzyDistance  = 2
+	DefaultFuzzyMinLength = 5
 	DefaultDB             = "openslides"
 	DefaultDBUser         = "openslides"
 	DefaultDBPassword     = "openslides"
@@ -36,9 +44,15 @@ const (
 
 // Web are the parameters for the web server.
 type Web struct {
-	Port     int
-	Host     string
-	MaxQueue int
+	Port         int
+	Host         string
+	MaxQueue     int
+	Workers      int
+	QueryTimeout time.Duration
+	CacheSize    int
+	// MetricsPort is the internal port serving the metrics.
+	// 0 disables them.
+	MetricsPort int
 }
 
 // Index are the parameters for the indexer.
@@ -46,7 +60,23 @@ type Index struct {
 	File   string
 	Age    time.Duration
 	Update time.Duration
-	Batch  int
+	// Sweep is the interval to compare all rows of the database
+	// with the index to find rows deleted from the table. If not
+	// set it is DefaultIndexSweep with notifications and the update
+	// interval without.
+	Sweep time.Duration
+	Batch int
+	// NotifyChannel enables updates by notifications if not empty.
+	NotifyChannel string
+}
+
+// Fuzzy are the parameters for typo tolerant matching.
+type Fuzzy struct {
+	// Distance is the maximum edit distance. 0 disables fuzzy matching.
+	Distance int
+	// MinLength is the number of characters a term needs
+	// to be matched fuzzily.
+	MinLength int
 }
 
 // Models are the paths to the YAML files containing the models
@@ -54,6 +84,8 @@ type Index struct {
 type Models struct {
 	Models string
 	Search string
+	// SearchWatch is the interval to check the search file for changes.
+	SearchWatch time.Duration
 }
 
 // Database are the credentials for the datavbase.
@@ -71,6 +103,7 @@ type Config struct {
 	LogLevel    logrus.Level
 	Web         Web
 	Index       Index
+	Fuzzy       Fuzzy
 	Models      Models
 	Database    Database
 	Restricter  Restricter
@@ -86,8 +119,13 @@ func GetConfig() (*Config, error) {
 	cfg := &Config{
 		LogLevel: logrus.InfoLevel,
 		Web: Web{
-			Port: DefaultWebPort,
-			Host: DefaultWebHost,
+			Port:         DefaultWebPort,
+			Host:         DefaultWebHost,
+			MetricsPort:  DefaultMetricsPort,
+			MaxQueue:     DefaultMaxQueue,
+			Workers:      DefaultWorkers,
+			QueryTimeout: DefaultQueryTimeout,
+			CacheSize:    DefaultCacheSize,
 		},
 		Index: Index{
 			File:   DefaultIndexFile,
@@ -95,9 +133,14 @@ func GetConfig() (*Config, error) {
 			Update: DefaultIndexUpdate,
 			Batch:  DefaultIndexBatch,
 		},
+		Fuzzy: Fuzzy{
+			Distance:  DefaultFuzzyDistance,
+			MinLength: DefaultFuzzyMinLength,
+		},
 		Models: Models{
-			Models: DefaultModels,
-			Search: DefaultSearch,
+			Models:      DefaultModels,
+			Search:      DefaultSearch,
+			SearchWatch: DefaultSearchWatch,
 		},
 		Database: Database{
 			Database: DefaultDB,
@@ -130,13 +173,22 @@ func (cfg *Config) fromEnv() error {
 		{"OPENSLIDES_LOG_LEVEL", storeLogLevel(&cfg.LogLevel)},
 		{"SEARCH_PORT", storeInt(&cfg.Web.Port)},
 		{"SEARCH_LISTEN_HOST", storeString(&cfg.Web.Host)},
+		{"SEARCH_METRICS_PORT", storeInt(&cfg.Web.MetricsPort)},
 		{"SEARCH_MAX_QUEUED", storeInt(&cfg.Web.MaxQueue)},
+		{"SEARCH_WORKERS", storeInt(&cfg.Web.Workers)},
+		{"SEARCH_QUERY_TIMEOUT", storeDuration(&cfg.Web.QueryTimeout)},
+		{"SEARCH_CACHE_SIZE", storeInt(&cfg.Web.CacheSize)},
 		{"SEARCH_INDEX_AGE", storeDuration(&cfg.Index.Age)},
 		{"SEARCH_INDEX_FILE", storeString(&cfg.Index.File)},
 		{"SEARCH_INDEX_BATCH", storeInt(&cfg.Index.Batch)},
 		{"SEARCH_INDEX_UPDATE_INTERVAL", storeDuration(&cfg.Index.Update)},
+		{"SEARCH_INDEX_SWEEP_INTERVAL", storeDuration(&cfg.Index.Sweep)},
+		{"SEARCH_INDEX_NOTIFY_CHANNEL", storeString(&cfg.Index.NotifyChannel)},
+		{"SEARCH_FUZZY_DISTANCE", storeInt(&cfg.Fuzzy.Distance)},
+		{"SEARCH_FUZZY_MIN_LENGTH", storeInt(&cfg.Fuzzy.MinLength)},
 		{"MODELS_YML_FILE", storeString(&cfg.Models.Models)},
 		{"SEARCH_YML_FILE", storeString(&cfg.Models.Search)},
+		{"SEARCH_YML_WATCH_INTERVAL", storeDuration(&cfg.Models.SearchWatch)},
 		{"DATABASE_NAME", storeString(&cfg.Database.Database)},
 		{"DATABASE_USER", storeString(&cfg.Database.User)},
 		{"DATABASE_PASSWORD_FILE", storeDBPassword(&cfg.Database.Password)},
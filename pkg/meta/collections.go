@@ -33,6 +33,9 @@ type CollectionDescription struct {
 	SearchableConfig map[string]*CollectionSearchableConfig `yaml:"searchable_config,omitempty"`
 	Additional       []string                               `yaml:"additional"`
 	Contains         []string                               `yaml:"contains,omitempty"`
+	Sortable         []string                               `yaml:"sortable,omitempty"`
+	Autocomplete     []string                               `yaml:"autocomplete,omitempty"`
+	Aliases          map[string][]string                    `yaml:"aliases,omitempty"`
 	Relations        map[string]*CollectionRelation         `yaml:"relations,omitempty"`
 }
 
@@ -118,16 +121,38 @@ func (ms Collections) AsFilters() Filters {
 
 		items := []string{}
 		additional := []string{}
+		var sortable, autocomplete []string
+		var aliases map[string][]string
 		for _, cKey := range cKeys {
-			if ms[k].Fields[cKey].Searchable {
+			f := ms[k].Fields[cKey]
+			if f.Searchable {
 				items = append(items, cKey)
 			} else {
 				additional = append(additional, cKey)
 			}
+			if f.Sortable {
+				sortable = append(sortable, cKey)
+			}
+			if f.Autocomplete {
+				autocomplete = append(autocomplete, cKey)
+			}
+			for _, alias := range f.Aliases {
+				if aliases == nil {
+					aliases = map[string][]string{}
+				}
+				aliases[alias] = append(aliases[alias], cKey)
+			}
 		}
 
 		if len(items) > 0 {
-			fs = append(fs, Filter{Name: k, Items: items, Additional: additional})
+			fs = append(fs, Filter{
+				Name:         k,
+				Items:        items,
+				Additional:   additional,
+				Sortable:     sortable,
+				Autocomplete: autocomplete,
+				Aliases:      aliases,
+			})
 		}
 	}
 	return fs
@@ -148,12 +173,33 @@ func (ms Collections) CollectionRequestFields() map[string]map[string]*Collectio
 	return collections
 }
 
+// Write writes the filters in the format of the search filter file.
 func (fs Filters) Write(w io.Writer) error {
 	b := bufio.NewWriter(w)
 
 	content := map[string]CollectionDescription{}
-	for i := range fs {
-		content[fs[i].Name] = CollectionDescription{Searchable: fs[i].Items, Additional: fs[i].Additional}
+	for _, f := range fs {
+		var contains []string
+		for c := range f.Contains {
+			contains = append(contains, c)
+		}
+		sort.Strings(contains)
+
+		var relations map[string]*CollectionRelation
+		if len(f.Relations) > 0 {
+			relations = f.Relations
+		}
+
+		content[f.Name] = CollectionDescription{
+			Searchable:       f.Items,
+			SearchableConfig: f.ItemsConfig,
+			Additional:       f.Additional,
+			Contains:         contains,
+			Sortable:         f.Sortable,
+			Autocomplete:     f.Autocomplete,
+			Aliases:          f.Aliases,
+			Relations:        relations,
+		}
 	}
 
 	if err := yaml.NewEncoder(b).Encode(content); err != nil {
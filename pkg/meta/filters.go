@@ -8,12 +8,16 @@ import (
 
 // Filter is part of the meta model.
 type Filter struct {
-	Name        string
-	Items       []string
-	ItemsConfig map[string]*CollectionSearchableConfig
-	Additional  []string
-	Contains    map[string]struct{}
-	Relations   map[string]*CollectionRelation
+	Name         string
+	Items        []string
+	ItemsConfig  map[string]*CollectionSearchableConfig
+	Additional   []string
+	Contains     map[string]struct{}
+	Sortable     []string
+	Autocomplete []string
+	// Aliases maps names usable in questions to fields.
+	Aliases   map[string][]string
+	Relations map[string]*CollectionRelation
 }
 
 // Filters is a list of filters.
@@ -39,12 +43,15 @@ func (fs *Filters) UnmarshalYAML(node []byte) error {
 		}
 
 		*fs = append(*fs, Filter{
-			Name:        k,
-			Items:       fsm[k].Searchable,
-			ItemsConfig: fsm[k].SearchableConfig,
-			Additional:  fsm[k].Additional,
-			Relations:   relations,
-			Contains:    contains,
+			Name:         k,
+			Items:        fsm[k].Searchable,
+			ItemsConfig:  fsm[k].SearchableConfig,
+			Additional:   fsm[k].Additional,
+			Relations:    relations,
+			Contains:     contains,
+			Sortable:     fsm[k].Sortable,
+			Autocomplete: fsm[k].Autocomplete,
+			Aliases:      fsm[k].Aliases,
 		})
 	}
 	return nil
@@ -75,6 +82,9 @@ func (fs Filters) Retain(verbose bool) func(string, string, *Member) bool {
 	}
 	keep := map[key]struct{}{}
 	additional := map[key]struct{}{}
+	sortable := map[key]struct{}{}
+	autocomplete := map[key]struct{}{}
+	aliases := map[key][]string{}
 	relations := map[key]*CollectionRelation{}
 	config := map[key]*CollectionSearchableConfig{}
 	for _, m := range fs {
@@ -90,6 +100,21 @@ func (fs Filters) Retain(verbose bool) func(string, string, *Member) bool {
 			additional[key{rel: m.Name, field: f}] = struct{}{}
 		}
 
+		for _, f := range m.Sortable {
+			sortable[key{rel: m.Name, field: f}] = struct{}{}
+		}
+
+		for _, f := range m.Autocomplete {
+			autocomplete[key{rel: m.Name, field: f}] = struct{}{}
+		}
+
+		for alias, fields := range m.Aliases {
+			for _, f := range fields {
+				k := key{rel: m.Name, field: f}
+				aliases[k] = append(aliases[k], alias)
+			}
+		}
+
 		for f, data := range m.Relations {
 			relations[key{rel: m.Name, field: f}] = data
 		}
@@ -107,12 +132,20 @@ func (fs Filters) Retain(verbose bool) func(string, string, *Member) bool {
 			m.Analyzer = c.Analyzer
 		}
 
+		_, m.Sortable = sortable[key{rel: rk, field: fk}]
+		_, m.Autocomplete = autocomplete[key{rel: rk, field: fk}]
+		m.Aliases = aliases[key{rel: rk, field: fk}]
+
 		if _, ok := additional[key{rel: rk, field: fk}]; ok {
 			m.Searchable = false
 			return true
 		}
 
 		_, ok := keep[key{rel: rk, field: fk}]
+		if !ok && m.Sortable {
+			m.Searchable = false
+			return true
+		}
 		if !ok && verbose {
 			log.Printf("removing filtered %s.%s\n", rk, fk)
 		} else {
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package meta
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+const filterFile = `
+motion:
+  searchable:
+    - title
+    - text
+  searchable_config:
+    text:
+      analyzer: html
+  additional:
+    - meeting_id
+  contains:
+    - motion_block
+  sortable:
+    - title
+    - sequential_number
+  autocomplete:
+    - title
+  aliases:
+    titel:
+      - title
+    inhalt:
+      - title
+      - text
+  relations:
+    submitter_ids:
+      type: to-many
+      collection: motion_submitter
+      fields:
+        meeting_user_id:
+          type: to-one
+          fields: {}
+motion_block:
+  searchable:
+    - title
+  additional: []
+`
+
+// parseFilters parses a filter file with the filters ordered by name.
+func parseFilters(t *testing.T, data []byte) Filters {
+	t.Helper()
+	var fs Filters
+	if err := yaml.Unmarshal(data, &fs); err != nil {
+		t.Fatalf("parsing filters: %v", err)
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	return fs
+}
+
+func TestFiltersRoundTrip(t *testing.T) {
+	want := parseFilters(t, []byte(filterFile))
+
+	var b bytes.Buffer
+	if err := want.Write(&b); err != nil {
+		t.Fatalf("writing filters: %v", err)
+	}
+	got := parseFilters(t, b.Bytes())
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("written filters\n%s\nare read as\n%+v\nwant\n%+v", b.String(), got, want)
+	}
+}
+
+func TestAsFilters(t *testing.T) {
+	collections := Collections{
+		"motion": {Fields: map[string]*Member{
+			"title":             {Searchable: true, Sortable: true, Autocomplete: true, Aliases: []string{"titel", "inhalt"}, Order: 1},
+			"text":              {Searchable: true, Aliases: []string{"inhalt"}, Order: 2},
+			"sequential_number": {Sortable: true, Order: 3},
+		}},
+	}
+
+	want := Filters{{
+		Name:         "motion",
+		Items:        []string{"title", "text"},
+		Additional:   []string{"sequential_number"},
+		Sortable:     []string{"title", "sequential_number"},
+		Autocomplete: []string{"title"},
+		Aliases: map[string][]string{
+			"titel":  {"title"},
+			"inhalt": {"title", "text"},
+		},
+	}}
+	if got := collections.AsFilters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AsFilters() = %+v, want %+v", got, want)
+	}
+}
@@ -24,6 +24,9 @@ type Member struct {
 	RestrictionMode       string              `yaml:"restriction_mode"`
 	Required              bool                `yaml:"required"`
 	Searchable            bool                `yaml:"-"`
+	Sortable              bool                `yaml:"-"`
+	Autocomplete          bool                `yaml:"-"`
+	Aliases               []string            `yaml:"-"`
 	Analyzer              *string             `yaml:"-"`
 	Relation              *CollectionRelation `yaml:"-"`
 	Order                 int32               `yaml:"-"`
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cacheKey returns the key of a request in the result cache.
+func (req *Request) cacheKey() string {
+	collections := make([]string, 0, len(req.Collections))
+	seen := make(map[string]struct{}, len(req.Collections))
+	for _, c := range req.Collections {
+		if _, ok := seen[c]; ok || c == "" {
+			continue
+		}
+		seen[c] = struct{}{}
+		collections = append(collections, c)
+	}
+	sort.Strings(collections)
+
+	return fmt.Sprintf("%q|%q|%d|%d|%d|%t|%q",
+		strings.Join(strings.Fields(req.Question), " "),
+		collections,
+		req.MeetingID,
+		req.From,
+		req.Size,
+		req.Facets,
+		req.Sort)
+}
+
+type cacheEntry struct {
+	key    string
+	gen    uint64
+	result *Result
+}
+
+// resultCache is a LRU cache of search results. An entry is only valid
+// for the generation of the text index it was searched in.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns the result cached for key in the given generation.
+func (c *resultCache) get(key string, gen uint64) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if entry.gen != gen {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.result, true
+}
+
+// add stores the result for key searched in the given generation.
+func (c *resultCache) add(key string, gen uint64, result *Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.gen > gen {
+			return
+		}
+		entry.gen, entry.result = gen, result
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:    key,
+		gen:    gen,
+		result: result,
+	})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
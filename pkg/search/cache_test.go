@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := Request{
+		Question:    "Haushalt 2024",
+		Collections: []string{"motion", "topic"},
+		MeetingID:   1,
+		Size:        10,
+		Sort:        []string{"score"},
+	}
+	key := base.cacheKey()
+
+	for _, tt := range []struct {
+		name   string
+		change func(*Request)
+	}{
+		{"whitespace", func(r *Request) { r.Question = "  Haushalt \t 2024 " }},
+		{"collection order", func(r *Request) { r.Collections = []string{"topic", "motion"} }},
+		{"duplicate collections", func(r *Request) { r.Collections = []string{"topic", "", "motion", "topic"} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base
+			tt.change(&req)
+			if got := req.cacheKey(); got != key {
+				t.Errorf("got key %s, want %s", got, key)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func(*Request)
+	}{
+		{"question", func(r *Request) { r.Question = "Haushalt 2025" }},
+		{"phrase", func(r *Request) { r.Question = `"Haushalt 2024"` }},
+		{"collections", func(r *Request) { r.Collections = []string{"motion"} }},
+		{"meeting", func(r *Request) { r.MeetingID = 2 }},
+		{"from", func(r *Request) { r.From = 10 }},
+		{"size", func(r *Request) { r.Size = 20 }},
+		{"facets", func(r *Request) { r.Facets = true }},
+		{"sort", func(r *Request) { r.Sort = []string{"-score"} }},
+	} {
+		t.Run("different "+tt.name, func(t *testing.T) {
+			req := base
+			tt.change(&req)
+			if got := req.cacheKey(); got == key {
+				t.Errorf("got the key %s of the unchanged request", got)
+			}
+		})
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	a, b, c := &Result{Total: 1}, &Result{Total: 2}, &Result{Total: 3}
+	cache := newResultCache(2)
+	cache.add("a", 1, a)
+	cache.add("b", 1, b)
+	// Using a makes b the least recently used entry.
+	if got, ok := cache.get("a", 1); !ok || got != a {
+		t.Fatalf("get(a) = %v, %t, want the result of a", got, ok)
+	}
+	cache.add("c", 1, c)
+
+	for _, tt := range []struct {
+		key  string
+		want *Result
+	}{
+		{"a", a},
+		{"b", nil},
+		{"c", c},
+	} {
+		got, ok := cache.get(tt.key, 1)
+		if got != tt.want || ok != (tt.want != nil) {
+			t.Errorf("get(%s) = %v, %t, want %v", tt.key, got, ok, tt.want)
+		}
+	}
+}
+
+func TestResultCacheGeneration(t *testing.T) {
+	old, current := &Result{Total: 1}, &Result{Total: 2}
+	cache := newResultCache(2)
+	cache.add("a", 2, current)
+	// A search of an older generation finishing late is not stored.
+	cache.add("a", 1, old)
+	if got, ok := cache.get("a", 2); !ok || got != current {
+		t.Errorf("get(a, 2) = %v, %t, want the current result", got, ok)
+	}
+
+	if got, ok := cache.get("a", 3); ok {
+		t.Errorf("get(a, 3) = %v, want no result of an older generation", got)
+	}
+	if len(cache.entries) != 0 || cache.order.Len() != 0 {
+		t.Errorf("outdated entry was kept")
+	}
+}
+
+func TestQueryServerCacheInvalidation(t *testing.T) {
+	ti := newProposalIndex(t)
+	qs := newTestQueryServer(t, ti, 1, 1, 0)
+	qs.cache = newResultCache(10)
+	// Without a database there is nothing to poll.
+	qs.listening.Store(true)
+
+	req := &Request{Question: "Haushalt", Size: 10}
+	first, err := qs.process(context.Background(), req)
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+	cached, err := qs.process(context.Background(), req)
+	if err != nil {
+		t.Fatalf("searching again: %v", err)
+	}
+	if cached != first {
+		t.Errorf("unchanged index was searched again")
+	}
+
+	doc := newBleveType("motion")
+	doc["title"] = "Haushalt 2025"
+	doc["_title_original"] = "Haushalt 2025"
+	if err := ti.index.Index("motion/4", doc); err != nil {
+		t.Fatalf("indexing: %v", err)
+	}
+	ti.gen.Add(1)
+
+	changed, err := qs.process(context.Background(), req)
+	if err != nil {
+		t.Fatalf("searching the changed index: %v", err)
+	}
+	if changed == first || !slices.Contains(changed.FQIDs, "motion/4") {
+		t.Errorf("got %v, want the result of the changed index", changed.FQIDs)
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+)
+
+const (
+	// completeMinLength is the number of characters a word needs
+	// to be completed.
+	completeMinLength = 2
+	// completeCandidates is the number of most frequent completions
+	// which are looked up in the documents.
+	completeCandidates = 50
+)
+
+// completeFields returns the dictionaries of the fields
+// marked for autocompletion.
+func completeFields(collections meta.Collections) []string {
+	return dictFieldsOf(collections, func(f *meta.Member) bool { return f.Autocomplete })
+}
+
+// Completions are the proposed completions of the last word of a prefix.
+type Completions struct {
+	head  string
+	word  string
+	terms []string
+	// Sources are documents containing the proposed terms.
+	Sources TermSources
+}
+
+// List returns up to limit completions. Only terms accepted by visible
+// are used, nil accepts all terms.
+func (c *Completions) List(visible func(term string) bool, limit int) []string {
+	completions := make([]string, 0, min(limit, len(c.terms)))
+	for _, term := range c.terms {
+		if len(completions) == limit {
+			break
+		}
+		if visible == nil || visible(term) {
+			completions = append(completions, c.head+matchCase(c.word, term))
+		}
+	}
+	return completions
+}
+
+// Complete returns the completions of the last word of the prefix with
+// the most frequent terms of the autocomplete fields. The preceding words
+// are kept. With a meeting only terms of documents of that meeting are
+// used.
+func (ti *TextIndex) Complete(ctx context.Context, prefix string, meetingID int) (*Completions, error) {
+	if !ti.ready.Load() {
+		return nil, NotReadyError{Progress: ti.fillProgress()}
+	}
+
+	completions := &Completions{Sources: TermSources{}}
+	words := strings.Fields(prefix)
+	if len(words) == 0 {
+		return completions, nil
+	}
+	word := words[len(words)-1]
+	last := strings.ToLower(word)
+	if utf8.RuneCountInString(last) < completeMinLength {
+		return completions, nil
+	}
+	completions.word = word
+	completions.head = strings.Join(words[:len(words)-1], " ")
+	if completions.head != "" {
+		completions.head += " "
+	}
+
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	if ti.index == nil {
+		return nil, errIndexClosed
+	}
+
+	counts := map[string]uint64{}
+	for _, field := range ti.completeFields {
+		dict, err := ti.index.FieldDictPrefix(field, []byte(last))
+		if err != nil {
+			return nil, fmt.Errorf("reading dictionary of %s failed: %w", field, err)
+		}
+		for {
+			entry, err := dict.Next()
+			if err != nil {
+				dict.Close()
+				return nil, fmt.Errorf("reading dictionary of %s failed: %w", field, err)
+			}
+			if entry == nil {
+				break
+			}
+			counts[entry.Term] += entry.Count
+		}
+		dict.Close()
+	}
+
+	candidates := make([]candidate, 0, len(counts))
+	for t, c := range counts {
+		candidates = append(candidates, candidate{term: t, count: c})
+	}
+	sortCandidates(candidates)
+	if len(candidates) > completeCandidates {
+		candidates = candidates[:completeCandidates]
+	}
+
+	candidates, err := ti.lookupCandidates(ctx, candidates, ti.completeFields,
+		newMeetingQuery(meetingID), completions.Sources)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range candidates {
+		completions.terms = append(completions.terms, c.term)
+	}
+	return completions, nil
+}
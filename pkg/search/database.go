@@ -6,10 +6,15 @@ package search
 
 import (
 	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/OpenSlides/openslides-search-service/pkg/config"
@@ -33,15 +38,53 @@ SELECT
 FROM models
 WHERE NOT deleted`
 
-	selectDiffSQL = `
+	selectFQIDsSQL = `
+SELECT
+  fqid,
+  data::text,
+  updated
+FROM models
+WHERE fqid = ANY($1::text[]) AND NOT deleted`
+
+	selectChangedSQL = `
+SELECT
+  fqid,
+  data::text,
+  updated,
+  deleted
+FROM models
+WHERE updated > $1`
+
+	countChangedSQL = `
+SELECT count(*)
+FROM models
+WHERE updated > $1`
+
+	selectUpdatedSQL = `
 SELECT
   fqid,
-  CASE WHEN updated > $1 THEN data::text ELSE NULL END,
   updated
 FROM models
 WHERE NOT deleted`
+
+	selectManagementLevelSQL = `
+SELECT data->>'organization_management_level'
+FROM models
+WHERE fqid = $1 AND NOT deleted`
 )
 
+// UpdatedIndexSQL creates the index on the models table which lets
+// updates fetch the changed rows without scanning the whole table.
+const UpdatedIndexSQL = `
+CREATE INDEX IF NOT EXISTS models_updated_idx ON models (updated);
+`
+
+// updateOverlap is the time an update looks back before the newest row
+// of the last one. The updated time of a row is the start of the
+// transaction writing it, so rows of transactions running longer are
+// only found by sweep.
+const updateOverlap = 5 * time.Second
+
 type entry struct {
 	updated time.Time
 	gen     uint16
@@ -49,10 +92,19 @@ type entry struct {
 
 // Database manages the updates needed to drive the text index.
 type Database struct {
-	cfg         *config.Config
-	last        time.Time
+	cfg *config.Config
+	// last is the local time of the last update.
+	last time.Time
+	// cursor is the newest updated time of the rows read so far.
+	// It is taken from the database to be independent of the clocks.
+	cursor      time.Time
+	swept       time.Time
 	gen         uint16
 	collections map[string]map[int]*entry
+	// fillTotal and fillDone count the rows of the initial
+	// fill or catch-up to report its progress.
+	fillTotal atomic.Int64
+	fillDone  atomic.Int64
 }
 
 // NewDatabase creates a new database,
@@ -63,7 +115,10 @@ func NewDatabase(cfg *config.Config) *Database {
 }
 
 func (db *Database) run(fn func(context.Context, *pgx.Conn) error) error {
-	ctx := context.Background()
+	return db.runContext(context.Background(), fn)
+}
+
+func (db *Database) runContext(ctx context.Context, fn func(context.Context, *pgx.Conn) error) error {
 	config, err := pgx.ParseConfig(db.cfg.Database.ConnectionConfig())
 	if err != nil {
 		return err
@@ -80,6 +135,67 @@ func (db *Database) run(fn func(context.Context, *pgx.Conn) error) error {
 	return fn(ctx, con)
 }
 
+// dbState is the persisted bookkeeping of a database.
+type dbState struct {
+	Fingerprint string
+	// Index is the directory of the index the state belongs to.
+	Index   string
+	Last    time.Time
+	Cursor  time.Time
+	Entries map[string]map[int]time.Time
+}
+
+// save writes the bookkeeping of the database for the index
+// in the given directory.
+func (db *Database) save(w io.Writer, fingerprint, dir string) error {
+	state := dbState{
+		Fingerprint: fingerprint,
+		Index:       dir,
+		Last:        db.last,
+		Cursor:      db.cursor,
+		Entries:     make(map[string]map[int]time.Time, len(db.collections)),
+	}
+	for col, entries := range db.collections {
+		updated := make(map[int]time.Time, len(entries))
+		for id, e := range entries {
+			updated[id] = e.updated
+		}
+		state.Entries[col] = updated
+	}
+	return gob.NewEncoder(w).Encode(&state)
+}
+
+// load restores the bookkeeping of the database written by save.
+// It fails if the state was saved with another fingerprint.
+// Returns the directory of the index the state belongs to.
+func (db *Database) load(r io.Reader, fingerprint string) (string, error) {
+	var state dbState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return "", fmt.Errorf("decoding state failed: %w", err)
+	}
+	if state.Fingerprint != fingerprint {
+		return "", fmt.Errorf("state fingerprint %q does not match %q",
+			state.Fingerprint, fingerprint)
+	}
+	cols := make(map[string]map[int]*entry, len(state.Entries))
+	for col, updated := range state.Entries {
+		entries := make(map[int]*entry, len(updated))
+		for id, u := range updated {
+			entries[id] = &entry{updated: u}
+		}
+		cols[col] = entries
+	}
+	db.collections = cols
+	db.last = state.Last
+	db.cursor = state.Cursor
+	if db.cursor.IsZero() {
+		// Written before the cursor was kept.
+		db.cursor = state.Last
+	}
+	db.gen = 0
+	return state.Index, nil
+}
+
 func (db *Database) numEntries() int {
 	if db.collections == nil {
 		return 0
@@ -115,13 +231,25 @@ type eventHandler func(evtType updateEventType, collection string, id int, data
 
 func nullEventHandler(updateEventType, string, int, []byte) error { return nil }
 
-func (db *Database) update(handler eventHandler) error {
-	start := time.Now()
+// outdated tells if the last update is older than the accepted age.
+func (db *Database) outdated(now time.Time) bool {
+	return db.last.IsZero() || now.After(db.last.Add(db.cfg.Index.Age))
+}
 
+// update emits the events for the rows changed since the last update.
+// Rows flipped to deleted are removed. Rows deleted from the table are
+// only detected by notifications and sweep.
+func (db *Database) update(handler eventHandler) error {
 	// Do not update if it is young enough.
-	if !db.last.IsZero() && !start.After(db.last.Add(db.cfg.Index.Age)) {
+	if !db.outdated(time.Now()) {
 		return nil
 	}
+	return db.forceUpdate(handler)
+}
+
+// forceUpdate is update regardless of the age of the last update.
+func (db *Database) forceUpdate(handler eventHandler) error {
+	start := time.Now()
 
 	if handler == nil {
 		handler = nullEventHandler
@@ -131,112 +259,338 @@ func (db *Database) update(handler eventHandler) error {
 		log.Debugf("updating database took %v\n", time.Since(start))
 	}()
 	return db.run(func(ctx context.Context, conn *pgx.Conn) error {
-		rows, err := conn.Query(ctx, selectDiffSQL, db.last)
+		// Rows may be committed with an updated timestamp before the
+		// last update, so look back a bit.
+		rows, err := conn.Query(ctx, selectChangedSQL, db.cursor.Add(-updateOverlap))
 		if err != nil {
 			return err
 		}
 		defer rows.Close()
 
-		before := db.numEntries()
-		var unchanged, added, entries int
-
-		ngen := db.gen + 1 // may overflow but thats okay.
+		var unchanged, added, changed, removed int
 
 		for rows.Next() {
 			var (
 				fqid    string
 				data    []byte
 				updated time.Time
+				deleted bool
 			)
-			if err := rows.Scan(&fqid, &data, &updated); err != nil {
+			if err := rows.Scan(&fqid, &data, &updated, &deleted); err != nil {
 				return err
 			}
-			entries++
+			db.fillDone.Add(1)
+			if updated.After(db.cursor) {
+				db.cursor = updated
+			}
 			col, id, err := splitFqid(fqid)
 			if err != nil {
 				log.Errorf("error: %v\n", err)
 				continue
 			}
-			// handle changed and new
 			collection := db.collections[col]
 			if collection == nil {
 				collection = make(map[int]*entry)
 				db.collections[col] = collection
 			}
 			e := collection[id]
-			if e == nil {
+			switch {
+			case deleted:
+				if e == nil {
+					continue
+				}
+				delete(collection, id)
+				if err := handler(removeEvent, col, id, nil); err != nil {
+					return err
+				}
+				removed++
+			case e == nil:
 				if err := handler(addedEvent, col, id, data); err != nil {
 					return err
 				}
 				collection[id] = &entry{
 					updated: updated,
-					gen:     ngen,
+					gen:     db.gen,
 				}
 				added++
-			} else {
+			case updated.After(e.updated):
+				// Changes may already be applied by notifications.
+				if err := handler(changedEvent, col, id, data); err != nil {
+					return err
+				}
 				e.updated = updated
+				changed++
+			default:
+				unchanged++
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		log.Debugf("unchanged: %d / added: %d / changed: %d / removed: %d\n",
+			unchanged, added, changed, removed)
+
+		db.last = start
+		return nil
+	})
+}
+
+// reconcile updates the bookkeeping and sweeps it if the last
+// sweep is older than the sweep interval.
+func (db *Database) reconcile(handler eventHandler) error {
+	if err := db.update(handler); err != nil {
+		return err
+	}
+	if !db.swept.IsZero() && time.Since(db.swept) < db.sweepInterval() {
+		return nil
+	}
+	return db.sweep(handler)
+}
+
+// sweepInterval returns the interval between sweeps. Without
+// notifications only sweeps find rows deleted from the table, so
+// by default they run with every reconcile.
+func (db *Database) sweepInterval() time.Duration {
+	switch {
+	case db.cfg.Index.Sweep > 0:
+		return db.cfg.Index.Sweep
+	case db.cfg.Index.NotifyChannel != "":
+		return config.DefaultIndexSweep
+	default:
+		return 0
+	}
+}
+
+// sweep compares all rows with the bookkeeping. Entries of rows which
+// are gone are removed, missing or outdated rows are fetched.
+func (db *Database) sweep(handler eventHandler) error {
+	if handler == nil {
+		handler = nullEventHandler
+	}
+
+	start := time.Now()
+	defer func() {
+		log.Debugf("sweeping database took %v\n", time.Since(start))
+	}()
+
+	var missing []string
+	if err := db.run(func(ctx context.Context, conn *pgx.Conn) error {
+		rows, err := conn.Query(ctx, selectUpdatedSQL)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		ngen := db.gen + 1 // may overflow but thats okay.
+
+		for rows.Next() {
+			var (
+				fqid    string
+				updated time.Time
+			)
+			if err := rows.Scan(&fqid, &updated); err != nil {
+				return err
+			}
+			db.fillDone.Add(1)
+			col, id, err := splitFqid(fqid)
+			if err != nil {
+				log.Errorf("error: %v\n", err)
+				continue
+			}
+			e := db.collections[col][id]
+			if e != nil {
 				e.gen = ngen
-				if data != nil {
-					if err := handler(changedEvent, col, id, data); err != nil {
-						return err
-					}
-				} else {
-					unchanged++
-				}
+			}
+			if e == nil || updated.After(e.updated) {
+				missing = append(missing, fqid)
 			}
 		}
 		if err := rows.Err(); err != nil {
 			return err
 		}
 
-		// TODO: Do some clever arithmetics based on
-		// before, entries, added and unchanged to
-		// early stop this.
 		var removed int
-		if unchanged != before {
-			for k, col := range db.collections {
-				for id, e := range col {
-					if e.gen != ngen {
-						removed++
-						delete(col, id)
-						if err := handler(removeEvent, k, id, nil); err != nil {
-							return err
-						}
+		for k, col := range db.collections {
+			for id, e := range col {
+				if e.gen != ngen {
+					removed++
+					delete(col, id)
+					if err := handler(removeEvent, k, id, nil); err != nil {
+						return err
 					}
 				}
 			}
 		}
+		log.Debugf("missing: %d / removed: %d\n", len(missing), removed)
+		db.fillTotal.Add(int64(len(missing)))
 
-		log.Debugf("entries: %d / before: %d\n",
-			entries, before)
-		log.Debugf("unchanged: %d / added: %d / removed: %d\n",
-			unchanged, added, removed)
-
-		db.last = start
 		db.gen = ngen
+		db.swept = start
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for len(missing) > 0 {
+		n := min(len(missing), db.cfg.Index.Batch)
+		if err := db.fetch(missing[:n], handler); err != nil {
+			return err
+		}
+		db.fillDone.Add(int64(n))
+		missing = missing[n:]
+	}
+	return nil
+}
+
+// catchUp brings the bookkeeping loaded by load up to date with an
+// update and a sweep. Its progress is reported like the one of fill.
+func (db *Database) catchUp(handler eventHandler) error {
+	var changed int64
+	if err := db.run(func(ctx context.Context, conn *pgx.Conn) error {
+		return conn.QueryRow(ctx, countChangedSQL, db.cursor.Add(-updateOverlap)).Scan(&changed)
+	}); err != nil {
+		return err
+	}
+	db.fillDone.Store(0)
+	db.fillTotal.Store(changed + int64(db.numEntries()))
+
+	if err := db.forceUpdate(handler); err != nil {
+		return err
+	}
+	return db.sweep(handler)
+}
+
+// fetch emits the events for the given fqids compared to the bookkeeping.
+// Fqids which are not found in the database are treated as removed.
+func (db *Database) fetch(fqids []string, handler eventHandler) error {
+	start := time.Now()
+	defer func() {
+		log.Debugf("fetching %d fqids took %v\n", len(fqids), time.Since(start))
+	}()
+
+	return db.run(func(ctx context.Context, conn *pgx.Conn) error {
+		rows, err := conn.Query(ctx, selectFQIDsSQL, fqids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		found := make(map[string]struct{}, len(fqids))
+		for rows.Next() {
+			var (
+				fqid    string
+				data    []byte
+				updated time.Time
+			)
+			if err := rows.Scan(&fqid, &data, &updated); err != nil {
+				return err
+			}
+			found[fqid] = struct{}{}
+			col, id, err := splitFqid(fqid)
+			if err != nil {
+				log.Errorf("error: %v\n", err)
+				continue
+			}
+			collection := db.collections[col]
+			if collection == nil {
+				collection = make(map[int]*entry)
+				db.collections[col] = collection
+			}
+			e := collection[id]
+			if e == nil {
+				if err := handler(addedEvent, col, id, data); err != nil {
+					return err
+				}
+				collection[id] = &entry{
+					updated: updated,
+					gen:     db.gen,
+				}
+				continue
+			}
+			// Already seen by an earlier update.
+			if !updated.After(e.updated) {
+				continue
+			}
+			if err := handler(changedEvent, col, id, data); err != nil {
+				return err
+			}
+			e.updated = updated
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, fqid := range fqids {
+			if _, ok := found[fqid]; ok {
+				continue
+			}
+			col, id, err := splitFqid(fqid)
+			if err != nil {
+				log.Errorf("error: %v\n", err)
+				continue
+			}
+			if _, ok := db.collections[col][id]; !ok {
+				continue
+			}
+			delete(db.collections[col], id)
+			if err := handler(removeEvent, col, id, nil); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
 
-func preAllocCollections(ctx context.Context, conn *pgx.Conn) (map[string]map[int]*entry, error) {
+func preAllocCollections(ctx context.Context, conn *pgx.Conn) (map[string]map[int]*entry, int, error) {
 	cols := make(map[string]map[int]*entry)
+	total := 0
 	rows, err := conn.Query(ctx, selectCollectionSizesSQL)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var size int
 		var col string
 		if err := rows.Scan(&size, &col); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		cols[col] = make(map[int]*entry, size)
+		total += size
 	}
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	return cols, total, nil
+}
+
+// managementLevel returns the organization management level of a user.
+func (db *Database) managementLevel(ctx context.Context, userID int) (string, error) {
+	var level *string
+	if err := db.runContext(ctx, func(ctx context.Context, conn *pgx.Conn) error {
+		err := conn.QueryRow(ctx, selectManagementLevelSQL, "user/"+strconv.Itoa(userID)).Scan(&level)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}); err != nil {
+		return "", err
 	}
-	return cols, nil
+	if level == nil {
+		return "", nil
+	}
+	return *level, nil
+}
+
+// fillProgress returns the progress of the initial fill in percent.
+func (db *Database) fillProgress() float64 {
+	total := db.fillTotal.Load()
+	if total == 0 {
+		return 0
+	}
+	done := float64(db.fillDone.Load()) * 100 / float64(total)
+	return math.Floor(min(100, done)*10) / 10
 }
 
 func (db *Database) fill(handler eventHandler) error {
@@ -250,10 +604,12 @@ func (db *Database) fill(handler eventHandler) error {
 	}
 
 	return db.run(func(ctx context.Context, conn *pgx.Conn) error {
-		cols, err := preAllocCollections(ctx, conn)
+		cols, total, err := preAllocCollections(ctx, conn)
 		if err != nil {
 			return err
 		}
+		db.fillDone.Store(0)
+		db.fillTotal.Store(int64(total))
 		rows, err := conn.Query(ctx, selectAllSQL)
 		if err != nil {
 			return err
@@ -290,8 +646,12 @@ func (db *Database) fill(handler eventHandler) error {
 			collection[id] = &entry{
 				updated: updated,
 			}
+			if updated.After(db.cursor) {
+				db.cursor = updated
+			}
 
 			numEntries++
+			db.fillDone.Add(1)
 		}
 		if err := rows.Err(); err != nil {
 			return err
@@ -303,6 +663,7 @@ func (db *Database) fill(handler eventHandler) error {
 		log.Debugf("num collections: %d\n", len(cols))
 		db.collections = cols
 		db.last = start
+		db.swept = start
 		return nil
 	})
 }
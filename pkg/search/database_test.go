@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+)
+
+func TestDatabaseStateKeepsCursor(t *testing.T) {
+	last := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cursor := last.Add(-time.Hour)
+
+	db := NewDatabase(&config.Config{})
+	db.last, db.cursor = last, cursor
+	db.collections = map[string]map[int]*entry{"motion": {1: {updated: cursor}}}
+
+	var buf bytes.Buffer
+	if err := db.save(&buf, "fp", "dir"); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+	loaded := NewDatabase(&config.Config{})
+	dir, err := loaded.load(&buf, "fp")
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if dir != "dir" || !loaded.last.Equal(last) || !loaded.cursor.Equal(cursor) {
+		t.Errorf("got dir %q, last %v, cursor %v, want dir, %v, %v",
+			dir, loaded.last, loaded.cursor, last, cursor)
+	}
+	if e := loaded.collections["motion"][1]; e == nil || !e.updated.Equal(cursor) {
+		t.Errorf("entry of motion/1 = %v, want updated %v", e, cursor)
+	}
+}
+
+func TestDatabaseStateWithoutCursor(t *testing.T) {
+	last := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&dbState{Fingerprint: "fp", Last: last}); err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	db := NewDatabase(&config.Config{})
+	if _, err := db.load(&buf, "fp"); err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if !db.cursor.Equal(last) {
+		t.Errorf("cursor = %v, want the last update %v", db.cursor, last)
+	}
+}
+
+func TestSweepInterval(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		sweep   time.Duration
+		channel string
+		want    time.Duration
+	}{
+		{"configured", time.Hour, "", time.Hour},
+		{"configured with notifications", time.Hour, "models", time.Hour},
+		{"notifications", 0, "models", config.DefaultIndexSweep},
+		{"polling", 0, "", 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Index.Sweep = tt.sweep
+			cfg.Index.NotifyChannel = tt.channel
+			if got := NewDatabase(cfg).sweepInterval(); got != tt.want {
+				t.Errorf("sweepInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
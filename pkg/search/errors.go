@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RequestError is returned if a search request is invalid.
+type RequestError struct {
+	msg string
+}
+
+func requestErrorf(format string, a ...any) RequestError {
+	return RequestError{msg: fmt.Sprintf(format, a...)}
+}
+
+func (e RequestError) Error() string {
+	return fmt.Sprintf("Invalid request: %s", e.msg)
+}
+
+// Type returns the type of the error for the client.
+func (e RequestError) Type() string {
+	return "invalid_request"
+}
+
+// ParseError is returned if a question does not follow the query
+// syntax.
+type ParseError struct {
+	// Position is the offset in characters of the error
+	// in the question.
+	Position int
+	msg      string
+}
+
+func parseErrorf(pos int, format string, a ...any) ParseError {
+	return ParseError{Position: pos, msg: fmt.Sprintf(format, a...)}
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.msg, e.Position)
+}
+
+// Type returns the type of the error for the client.
+func (e ParseError) Type() string {
+	return "invalid_query"
+}
+
+// Details returns additional fields of the error for the client.
+func (e ParseError) Details() map[string]any {
+	return map[string]any{"position": e.Position}
+}
+
+// TimeoutError is returned if a query takes longer than allowed.
+type TimeoutError struct {
+	timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("query took longer than %v", e.timeout)
+}
+
+// Type returns the type of the error for the client.
+func (e TimeoutError) Type() string {
+	return "timeout"
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e TimeoutError) StatusCode() int {
+	return http.StatusGatewayTimeout
+}
+
+// OverloadError is returned if a query is rejected because the query
+// queue is full.
+type OverloadError struct {
+	retryAfter time.Duration
+}
+
+func (e OverloadError) Error() string {
+	return "too many queries, try again later"
+}
+
+// Type returns the type of the error for the client.
+func (e OverloadError) Type() string {
+	return "queue_full"
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e OverloadError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// RetryAfter returns the time after which the client may try again.
+func (e OverloadError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// NotReadyError is returned if the text index can not be searched
+// because it is still being built.
+type NotReadyError struct {
+	// Progress of the build in percent.
+	Progress float64
+}
+
+func (e NotReadyError) Error() string {
+	return fmt.Sprintf("the text index is being built (%g%% done)", e.Progress)
+}
+
+// Type returns the type of the error for the client.
+func (e NotReadyError) Type() string {
+	return "index_not_ready"
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e NotReadyError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// Details returns additional fields of the error for the client.
+func (e NotReadyError) Details() map[string]any {
+	return map[string]any{"progress": e.Progress}
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newQueryDuration creates the histogram of the query latency.
+func newQueryDuration() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_query_duration_seconds",
+		Help:    "Time needed to answer a query including waiting in the queue.",
+		Buckets: prometheus.DefBuckets,
+	})
+}
+
+// newUpdateDuration creates the histogram of the duration of index updates.
+func newUpdateDuration() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_index_update_duration_seconds",
+		Help:    "Time needed to apply an update to the text index.",
+		Buckets: []float64{.005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	})
+}
+
+// newUpdateBatchSize creates the histogram of the size of index updates.
+func newUpdateBatchSize() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_index_update_batch_size",
+		Help:    "Number of documents changed by an update of the text index.",
+		Buckets: []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000, 50000},
+	})
+}
+
+// RegisterMetrics registers the metrics of the query server and its
+// text index.
+func (qs *QueryServer) RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		qs.queryDuration,
+		qs.ti.updateDuration,
+		qs.ti.updateBatchSize,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "search_query_queue_length",
+			Help: "Number of queries waiting in the queue.",
+		}, func() float64 { return float64(len(qs.queries)) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "search_queries_rejected_total",
+			Help: "Number of queries rejected because the queue was full.",
+		}, func() float64 { return float64(qs.Rejected()) }),
+		documentCollector{ti: qs.ti},
+	} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var documentsDesc = prometheus.NewDesc(
+	"search_index_documents",
+	"Number of documents in the text index per collection.",
+	[]string{"collection"},
+	nil)
+
+// documentCollector exposes the number of indexed documents per collection.
+type documentCollector struct {
+	ti *TextIndex
+}
+
+func (c documentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- documentsDesc
+}
+
+func (c documentCollector) Collect(ch chan<- prometheus.Metric) {
+	for col, count := range c.ti.documentCounts() {
+		ch <- prometheus.MustNewConstMetric(documentsDesc, prometheus.GaugeValue, count, col)
+	}
+}
+
+// documentCounts returns the number of indexed documents per collection.
+func (ti *TextIndex) documentCounts() map[string]float64 {
+	if !ti.ready.Load() {
+		return nil
+	}
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	counts := make(map[string]float64, len(ti.collections))
+	for col := range ti.collections {
+		counts[col] = float64(len(ti.db.collections[col]))
+	}
+	return counts
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sampleCounts returns the number of samples of each histogram.
+func sampleCounts(t *testing.T, reg *prometheus.Registry) map[string]uint64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gathering metrics: %v", err)
+	}
+	counts := map[string]uint64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				counts[mf.GetName()] += h.GetSampleCount()
+			}
+		}
+	}
+	return counts
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	ti := newProposalIndex(t)
+	ti.db = &Database{collections: map[string]map[int]*entry{
+		"motion": {1: nil, 2: nil, 3: nil},
+	}}
+
+	// Every query server has metrics of its own.
+	for i := 0; i < 2; i++ {
+		reg := prometheus.NewRegistry()
+		qs := newTestQueryServer(t, ti, 1, 1, 0)
+		if err := qs.RegisterMetrics(reg); err != nil {
+			t.Fatalf("registering metrics: %v", err)
+		}
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("gathering metrics: %v", err)
+		}
+		names := map[string]bool{}
+		for _, mf := range families {
+			names[mf.GetName()] = true
+		}
+		for _, name := range []string{
+			"search_query_duration_seconds",
+			"search_query_queue_length",
+			"search_queries_rejected_total",
+			"search_index_documents",
+		} {
+			if !names[name] {
+				t.Errorf("metric %s is missing in %v", name, names)
+			}
+		}
+	}
+}
+
+func TestEmptyUpdateHasNoBatchSize(t *testing.T) {
+	ti := newProposalIndex(t)
+	ti.cfg.Index.Batch = 10
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(ti.updateDuration, ti.updateBatchSize)
+
+	if err := ti.apply(func(eventHandler) error { return nil }); err != nil {
+		t.Fatalf("applying nothing: %v", err)
+	}
+	counts := sampleCounts(t, reg)
+	if got := counts["search_index_update_duration_seconds"]; got != 1 {
+		t.Errorf("got %d update durations, want 1", got)
+	}
+	if got := counts["search_index_update_batch_size"]; got != 0 {
+		t.Errorf("got %d batch sizes of an empty update, want none", got)
+	}
+}
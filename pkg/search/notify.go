@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyRetry is the delay before listening again after a failure.
+const notifyRetry = 5 * time.Second
+
+const notifyTriggerSQL = `
+CREATE OR REPLACE FUNCTION notify_search_service() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('%[1]s', OLD.fqid);
+  ELSE
+    PERFORM pg_notify('%[1]s', NEW.fqid);
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_search_service ON models;
+CREATE TRIGGER notify_search_service
+AFTER INSERT OR UPDATE OR DELETE ON models
+FOR EACH ROW EXECUTE FUNCTION notify_search_service();
+`
+
+// NotifyTriggerSQL returns the SQL to install a trigger on the models
+// table which sends the fqids of changed rows to the given channel.
+func NotifyTriggerSQL(channel string) string {
+	return fmt.Sprintf(notifyTriggerSQL, strings.ReplaceAll(channel, "'", "''"))
+}
+
+// listen subscribes to the notification channel and sends the fqids of
+// the changed rows. As notifications get lost while not listening, an
+// empty fqid is sent after each (re)connect to request a full update.
+// listening tells if notifications are currently received.
+func (db *Database) listen(ctx context.Context, notified chan<- string, listening *atomic.Bool) {
+	channel := db.cfg.Index.NotifyChannel
+	for {
+		err := db.runContext(ctx, func(ctx context.Context, conn *pgx.Conn) error {
+			if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			log.Infof("listening for changes on channel %q\n", channel)
+			listening.Store(true)
+			defer listening.Store(false)
+
+			select {
+			case notified <- "":
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			for {
+				n, err := conn.WaitForNotification(ctx)
+				if err != nil {
+					return err
+				}
+				select {
+				case notified <- n.Payload:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warnf("listening on channel %q failed: %v\n", channel, err)
+
+		select {
+		case <-time.After(notifyRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
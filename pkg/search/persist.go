@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+)
+
+// stateVersion has to be increased if the documents written to the
+// index change in a way which is not covered by the fingerprint.
+const stateVersion = 1
+
+// stateFile is the file next to the index storing the bookkeeping
+// of the database.
+func (ti *TextIndex) stateFile() string {
+	return ti.cfg.Index.File + ".state"
+}
+
+// fingerprint identifies the index mapping and the indexed fields.
+// A persisted index is only reused if its fingerprint matches.
+func (ti *TextIndex) fingerprint() (string, error) {
+	return fingerprint(ti.indexMapping, ti.collections)
+}
+
+func fingerprint(indexMapping mapping.IndexMapping, collections meta.Collections) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "version %d\n", stateVersion)
+
+	m, err := json.Marshal(indexMapping)
+	if err != nil {
+		return "", fmt.Errorf("encoding index mapping failed: %w", err)
+	}
+	h.Write(m)
+
+	cnames := make([]string, 0, len(collections))
+	for cname := range collections {
+		cnames = append(cnames, cname)
+	}
+	sort.Strings(cnames)
+	for _, cname := range cnames {
+		fields := collections[cname].Fields
+		fnames := make([]string, 0, len(fields))
+		for fname := range fields {
+			fnames = append(fnames, fname)
+		}
+		sort.Strings(fnames)
+		for _, fname := range fnames {
+			f := fields[fname]
+			var analyzer string
+			if f.Analyzer != nil {
+				analyzer = *f.Analyzer
+			}
+			fmt.Fprintf(h, "%s.%s %s %t %t %s\n",
+				cname, fname, f.Type, f.Searchable, f.Sortable, analyzer)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reopen opens the index persisted by a previous run and catches up
+// with the changes in the database since then.
+func (ti *TextIndex) reopen() error {
+	start := time.Now()
+
+	fingerprint, err := ti.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(ti.stateFile())
+	if err != nil {
+		return err
+	}
+	dir, err := ti.db.load(f, fingerprint)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = ti.cfg.Index.File
+	}
+
+	// The state is only valid as long as the index is not written again.
+	// Without it an unclean shutdown leads to a rebuild.
+	if err := os.Remove(ti.stateFile()); err != nil {
+		return fmt.Errorf("removing state file failed: %w", err)
+	}
+
+	index, err := bleve.Open(dir)
+	if err != nil {
+		return fmt.Errorf(
+			"opening index file %q failed: %w", dir, err)
+	}
+	ti.use(index, dir)
+	ti.removeStaleIndexes(dir)
+
+	if err := ti.apply(ti.db.catchUp); err != nil {
+		ti.index, ti.alias = nil, nil
+		index.Close()
+		return fmt.Errorf("catching up with database failed: %w", err)
+	}
+
+	log.Infof("reopening text index took %v\n", time.Since(start))
+	return nil
+}
+
+// persist writes the bookkeeping of the database next to the index,
+// so it can be reopened by the next run.
+func (ti *TextIndex) persist() error {
+	fingerprint, err := ti.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	tmp := ti.stateFile() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating state file failed: %w", err)
+	}
+	if err := ti.db.save(f, fingerprint, ti.dir); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("writing state file failed: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing state file failed: %w", err)
+	}
+	return os.Rename(tmp, ti.stateFile())
+}
+
+// rebuiltIndexDir returns a new directory for a rebuilt index.
+func (ti *TextIndex) rebuiltIndexDir() string {
+	return fmt.Sprintf("%s-%d", ti.cfg.Index.File, time.Now().UnixNano())
+}
+
+// removeStaleIndexes removes the directories of rebuilt indexes
+// left over by previous runs except the given one. Other files
+// next to the index are kept.
+func (ti *TextIndex) removeStaleIndexes(keep string) {
+	parent := filepath.Dir(ti.cfg.Index.File)
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		log.Warnf("looking for stale indexes failed: %v\n", err)
+		return
+	}
+	prefix := filepath.Base(ti.cfg.Index.File) + "-"
+	for _, entry := range entries {
+		// Rebuilt indexes are named by rebuiltIndexDir.
+		generation, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.ParseUint(generation, 10, 64); err != nil {
+			continue
+		}
+		dir := filepath.Join(parent, entry.Name())
+		if dir == filepath.Clean(keep) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warnf("removing stale index %q failed: %v\n", dir, err)
+		}
+	}
+	if keep != ti.cfg.Index.File {
+		if err := os.RemoveAll(ti.cfg.Index.File); err != nil {
+			log.Warnf("removing stale index %q failed: %v\n", ti.cfg.Index.File, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+)
+
+func TestRemoveStaleIndexes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Index.File = filepath.Join(dir, "search.bleve")
+	ti := &TextIndex{cfg: cfg}
+
+	for _, name := range []string{
+		"search.bleve",
+		"search.bleve-1700000000000000000",
+		"search.bleve-1700000000000000001",
+		"search.bleve-backup",
+		"search.bleve-12-old",
+		"other",
+	} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatalf("creating directory: %v", err)
+		}
+	}
+	for _, name := range []string{
+		"search.bleve-1700000000000000002",
+		"search.bleve.state",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("creating file: %v", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "other"), filepath.Join(dir, "search.bleve-1700000000000000003")); err != nil {
+		t.Fatalf("creating link: %v", err)
+	}
+
+	ti.removeStaleIndexes(filepath.Join(dir, "search.bleve-1700000000000000001"))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading directory: %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	sort.Strings(got)
+	want := []string{
+		"other",
+		"search.bleve-12-old",
+		"search.bleve-1700000000000000001",
+		"search.bleve-1700000000000000002",
+		"search.bleve-1700000000000000003",
+		"search.bleve-backup",
+		"search.bleve.state",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+}
@@ -9,36 +9,68 @@ import (
 	"context"
 	"errors"
 	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/OpenSlides/openslides-search-service/pkg/config"
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type queryItem struct {
-	q           string
-	meeting     int
-	collections []string
-	fn          func(map[string]Answer, error)
+	ctx context.Context
+	req *Request
+	fn  func(*Result, error)
 }
 
 // QueryServer manages incoming queries against the database.
 type QueryServer struct {
 	queries chan queryItem
-	ti      *TextIndex
-	cfg     *config.Config
+	// completing admits as many completions as queries can be queued
+	// and worked on, of which completers limits the running ones.
+	completing    chan struct{}
+	completers    chan struct{}
+	notified      chan string
+	listening     atomic.Bool
+	rejected      atomic.Uint64
+	cache         *resultCache
+	flightsMu     sync.Mutex
+	flights       map[string]*flight
+	queryDuration prometheus.Histogram
+	ti            *TextIndex
+	cfg           *config.Config
 }
 
 // NewQueryServer creates a new query server with the help of a text index.
 func NewQueryServer(cfg *config.Config, ti *TextIndex) (*QueryServer, error) {
-	return &QueryServer{
-		queries: make(chan queryItem, cfg.Web.MaxQueue),
-		ti:      ti,
-		cfg:     cfg,
-	}, nil
+	qs := &QueryServer{
+		queries:       make(chan queryItem, cfg.Web.MaxQueue),
+		completing:    make(chan struct{}, cfg.Web.MaxQueue+max(1, cfg.Web.Workers)),
+		completers:    make(chan struct{}, max(1, cfg.Web.Workers)),
+		notified:      make(chan string, cfg.Index.Batch),
+		flights:       map[string]*flight{},
+		queryDuration: newQueryDuration(),
+		ti:            ti,
+		cfg:           cfg,
+	}
+	if cfg.Web.CacheSize > 0 {
+		qs.cache = newResultCache(cfg.Web.CacheSize)
+	}
+	return qs, nil
 }
 
-// Run starts the query server.
+// Run starts the query server. The queries are answered concurrently
+// by the configured number of workers.
 func (qs *QueryServer) Run(ctx context.Context) {
+	if qs.cfg.Index.NotifyChannel != "" {
+		go qs.ti.db.listen(ctx, qs.notified, &qs.listening)
+	}
+
+	for i := 0; i < max(1, qs.cfg.Web.Workers); i++ {
+		go qs.work(ctx)
+	}
+
 	ticker := time.NewTicker(qs.cfg.Index.Update)
 	defer ticker.Stop()
 	for {
@@ -47,38 +79,324 @@ func (qs *QueryServer) Run(ctx context.Context) {
 			log.Info("shutting down query server")
 			return
 		case <-ticker.C:
-			if err := qs.ti.update(); err != nil {
+			if err := qs.ti.reconcile(); err != nil {
 				log.Errorf("updating text index failed: %v\n", err)
 			}
+		case fqid := <-qs.notified:
+			if err := qs.applyNotified(fqid); err != nil {
+				log.Errorf("updating text index failed: %v\n", err)
+			}
+		}
+	}
+}
+
+// work answers queries until the context is done.
+func (qs *QueryServer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
 		case qi := <-qs.queries:
-			// update the database before searching
-			if err := qs.ti.update(); err != nil {
+			// The client may have given up while waiting in the queue.
+			if err := qi.ctx.Err(); err != nil {
 				qi.fn(nil, err)
 				continue
 			}
-			qi.fn(qs.ti.Search(qi.q, qi.collections, qi.meeting))
+			qi.fn(qs.process(qi.ctx, qi.req))
+		}
+	}
+}
+
+// process brings the index up to date and searches it.
+func (qs *QueryServer) process(ctx context.Context, req *Request) (*Result, error) {
+	if err := qs.applyNotified(); err != nil {
+		return nil, err
+	}
+	// Without notifications the database has to be polled.
+	if !qs.listening.Load() && qs.ti.outdated() {
+		if err := qs.ti.update(); err != nil {
+			return nil, err
+		}
+	}
+	if qs.cache == nil {
+		return qs.ti.Search(ctx, req)
+	}
+
+	// An update which changed nothing keeps the cached result valid.
+	key, gen := req.cacheKey(), qs.ti.generation()
+	if result, ok := qs.cache.get(key, gen); ok {
+		return result, nil
+	}
+	result, err := qs.ti.Search(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	qs.cache.add(key, gen, result)
+	return result, nil
+}
+
+// cached returns the cached result of a request if the index
+// does not need to be updated before searching.
+func (qs *QueryServer) cached(req *Request) (*Result, bool) {
+	if qs.cache == nil || len(qs.notified) > 0 {
+		return nil, false
+	}
+	if !qs.listening.Load() && qs.ti.outdated() {
+		return nil, false
+	}
+	return qs.cache.get(req.cacheKey(), qs.ti.generation())
+}
+
+// applyNotified updates the index with the given and all pending
+// notified fqids. An empty fqid requests a full update.
+func (qs *QueryServer) applyNotified(fqids ...string) error {
+drain:
+	for len(fqids) < qs.cfg.Index.Batch {
+		select {
+		case fqid := <-qs.notified:
+			fqids = append(fqids, fqid)
+		default:
+			break drain
+		}
+	}
+	if len(fqids) == 0 {
+		return nil
+	}
+
+	changed := fqids[:0]
+	full := false
+	seen := make(map[string]struct{}, len(fqids))
+	for _, fqid := range fqids {
+		if fqid == "" {
+			full = true
+			continue
+		}
+		if _, ok := seen[fqid]; !ok {
+			seen[fqid] = struct{}{}
+			changed = append(changed, fqid)
 		}
 	}
+
+	if full {
+		if err := qs.ti.update(); err != nil {
+			return err
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return qs.ti.updateFQIDs(changed)
 }
 
-var errQueryQueueFull = errors.New("query queue full")
+// Ready returns nil if queries can be answered by an up to date index.
+func (qs *QueryServer) Ready() error {
+	return qs.ti.Ready()
+}
+
+// BuildProgress returns the progress in percent of building
+// the text index.
+func (qs *QueryServer) BuildProgress() float64 {
+	if qs.ti.ready.Load() {
+		return 100
+	}
+	return qs.ti.fillProgress()
+}
+
+// Rebuild starts a rebuild of the text index in the background.
+func (qs *QueryServer) Rebuild() error {
+	return qs.ti.Rebuild()
+}
+
+// Complete returns completions of the last word of the prefix.
+// It does not wait for queued queries or update the text index.
+// Like queries, completions are limited by the number of workers
+// and the queue size and give up after the query timeout.
+func (qs *QueryServer) Complete(ctx context.Context, prefix string, meetingID int) (*Completions, error) {
+	select {
+	case qs.completing <- struct{}{}:
+		defer func() { <-qs.completing }()
+	default:
+		n := qs.rejected.Add(1)
+		log.Debugf("completions exceed the queue, %d queries rejected so far\n", n)
+		return nil, OverloadError{retryAfter: queueRetryAfter}
+	}
+
+	parent := ctx
+	if timeout := qs.cfg.Web.QueryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	select {
+	case qs.completers <- struct{}{}:
+		defer func() { <-qs.completers }()
+	case <-ctx.Done():
+		return nil, qs.timeoutError(parent, ctx.Err())
+	}
+
+	completions, err := qs.ti.Complete(ctx, prefix, meetingID)
+	if err != nil {
+		return nil, qs.timeoutError(parent, err)
+	}
+	return completions, nil
+}
+
+// Reload replaces the searched collections. The index is rebuilt
+// in the background if needed and swapped is called when done.
+func (qs *QueryServer) Reload(collections meta.Collections, swapped func()) error {
+	return qs.ti.Reload(collections, swapped)
+}
+
+// ForceUpdate updates the text index regardless of its age.
+func (qs *QueryServer) ForceUpdate() error {
+	return qs.ti.ForceUpdate()
+}
+
+// Status returns the state of the text index.
+func (qs *QueryServer) Status() Status {
+	return qs.ti.Status()
+}
+
+// IsSuperadmin tells if the user is allowed to administer the index.
+func (qs *QueryServer) IsSuperadmin(ctx context.Context, userID int) (bool, error) {
+	return qs.ti.IsSuperadmin(ctx, userID)
+}
+
+// queueRetryAfter is the time clients are asked to wait
+// if the query queue is full.
+const queueRetryAfter = time.Second
+
+// Rejected returns the number of queries rejected because the
+// query queue was full.
+func (qs *QueryServer) Rejected() uint64 {
+	return qs.rejected.Load()
+}
+
+// Query searches the database for hits. Returns a page of answers
+// keyed by fqid. It gives up when the context is done or the query
+// takes longer than the configured timeout. Identical queries running
+// at the same time share one search. The result may be shared with
+// other queries and must not be modified.
+func (qs *QueryServer) Query(ctx context.Context, req *Request) (*Result, error) {
+	start := time.Now()
+	defer func() { qs.queryDuration.Observe(time.Since(start).Seconds()) }()
+
+	if !qs.ti.ready.Load() {
+		return nil, NotReadyError{Progress: qs.ti.fillProgress()}
+	}
+	if result, ok := qs.cached(req); ok {
+		return result, nil
+	}
+
+	parent := ctx
+	if timeout := qs.cfg.Web.QueryTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	key := req.cacheKey()
+	f, err := qs.join(key, req)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, qs.timeoutError(parent, f.err)
+		}
+		return f.result, nil
+	case <-ctx.Done():
+		qs.leave(key, f)
+		return nil, qs.timeoutError(parent, ctx.Err())
+	}
+}
+
+// flight is a query in progress shared by all identical queries.
+type flight struct {
+	done   chan struct{}
+	result *Result
+	err    error
+	cancel context.CancelFunc
+	// waiters is guarded by the flightsMu of the query server.
+	waiters int
+}
+
+// join returns the flight of an identical query in progress or
+// queues a new one.
+func (qs *QueryServer) join(key string, req *Request) (*flight, error) {
+	qs.flightsMu.Lock()
+	defer qs.flightsMu.Unlock()
+
+	if f, ok := qs.flights[key]; ok {
+		f.waiters++
+		return f, nil
+	}
+
+	// The search must not depend on the context of a single waiter.
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout := qs.cfg.Web.QueryTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	f := &flight{
+		done:    make(chan struct{}),
+		cancel:  cancel,
+		waiters: 1,
+	}
 
-// Query searches the database for hits. Returns a list of fqids.
-func (qs *QueryServer) Query(q string, collections []string, meeting int) (answers map[string]Answer, err error) {
-	done := make(chan struct{})
 	select {
 	case qs.queries <- queryItem{
-		q:           q,
-		collections: collections,
-		meeting:     meeting,
-		fn: func(as map[string]Answer, e error) {
-			answers, err = as, e
-			close(done)
+		ctx: ctx,
+		req: req,
+		fn: func(r *Result, e error) {
+			qs.flightsMu.Lock()
+			if qs.flights[key] == f {
+				delete(qs.flights, key)
+			}
+			qs.flightsMu.Unlock()
+			f.result, f.err = r, e
+			cancel()
+			close(f.done)
 		},
 	}:
 	default:
-		return nil, errQueryQueueFull
+		cancel()
+		n := qs.rejected.Add(1)
+		log.Debugf("query queue full, %d queries rejected so far\n", n)
+		return nil, OverloadError{retryAfter: queueRetryAfter}
+	}
+
+	qs.flights[key] = f
+	return f, nil
+}
+
+// leave removes a waiter from a flight. The search is canceled
+// if nobody waits for it anymore.
+func (qs *QueryServer) leave(key string, f *flight) {
+	qs.flightsMu.Lock()
+	defer qs.flightsMu.Unlock()
+
+	if f.waiters--; f.waiters > 0 {
+		return
+	}
+	if qs.flights[key] == f {
+		delete(qs.flights, key)
+	}
+	f.cancel()
+}
+
+// timeoutError replaces errors caused by the query timeout with a
+// TimeoutError. Errors of the parent context are kept.
+func (qs *QueryServer) timeoutError(parent context.Context, err error) error {
+	if parent.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return TimeoutError{timeout: qs.cfg.Web.QueryTimeout}
 	}
-	<-done
-	return
+	return err
 }
@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+)
+
+// newTestQueryServer creates a query server for the text index
+// without running it.
+func newTestQueryServer(t *testing.T, ti *TextIndex, workers, queue int, timeout time.Duration) *QueryServer {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Web.Workers = workers
+	cfg.Web.MaxQueue = queue
+	cfg.Web.QueryTimeout = timeout
+	qs, err := NewQueryServer(cfg, ti)
+	if err != nil {
+		t.Fatalf("creating query server: %v", err)
+	}
+	return qs
+}
+
+func TestCompleteLimits(t *testing.T) {
+	ti := newProposalIndex(t)
+
+	t.Run("completes", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 0, time.Second)
+		completions, err := qs.Complete(context.Background(), "Haus", 0)
+		if err != nil {
+			t.Fatalf("completing: %v", err)
+		}
+		if len(completions.terms) == 0 {
+			t.Errorf("got no completions")
+		}
+	})
+
+	t.Run("overload", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 0, time.Second)
+		qs.completing <- struct{}{}
+
+		_, err := qs.Complete(context.Background(), "Haus", 0)
+		if !errors.As(err, new(OverloadError)) {
+			t.Fatalf("got %v, want an OverloadError", err)
+		}
+		if got := qs.Rejected(); got != 1 {
+			t.Errorf("rejected = %d, want 1", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, 10*time.Millisecond)
+		qs.completers <- struct{}{}
+
+		_, err := qs.Complete(context.Background(), "Haus", 0)
+		if !errors.As(err, new(TimeoutError)) {
+			t.Fatalf("got %v, want a TimeoutError", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, time.Second)
+		qs.completers <- struct{}{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := qs.Complete(ctx, "Haus", 0)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	})
+}
+
+// queryResult is the outcome of a query run in the background.
+type queryResult struct {
+	result *Result
+	err    error
+}
+
+// queryAsync runs a query in the background.
+func queryAsync(ctx context.Context, qs *QueryServer, req *Request) <-chan queryResult {
+	done := make(chan queryResult, 1)
+	go func() {
+		result, err := qs.Query(ctx, req)
+		done <- queryResult{result, err}
+	}()
+	return done
+}
+
+// nextQuery returns the next queued query. The tests answer them
+// instead of the workers.
+func nextQuery(t *testing.T, qs *QueryServer) queryItem {
+	t.Helper()
+	select {
+	case qi := <-qs.queries:
+		return qi
+	case <-time.After(time.Second):
+		t.Fatal("no query was queued")
+		return queryItem{}
+	}
+}
+
+// waitForWaiters waits until the flight of the request has n waiters.
+func waitForWaiters(t *testing.T, qs *QueryServer, req *Request, n int) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		qs.flightsMu.Lock()
+		f := qs.flights[req.cacheKey()]
+		ok := f != nil && f.waiters == n
+		qs.flightsMu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("flight has not %d waiters", n)
+}
+
+func TestQueryFlights(t *testing.T) {
+	ti := newProposalIndex(t)
+	req := &Request{Question: "Haushalt", Size: 10}
+
+	t.Run("share the result", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 2, 0)
+		first := queryAsync(context.Background(), qs, req)
+		qi := nextQuery(t, qs)
+		second := queryAsync(context.Background(), qs, &Request{Question: " Haushalt ", Size: 10})
+		waitForWaiters(t, qs, req, 2)
+		if len(qs.queries) != 0 {
+			t.Fatalf("identical query was queued again")
+		}
+
+		want := &Result{FQIDs: []string{"motion/1"}}
+		qi.fn(want, nil)
+		for _, done := range []<-chan queryResult{first, second} {
+			if got := <-done; got.err != nil || got.result != want {
+				t.Errorf("got %v, %v, want the shared result", got.result, got.err)
+			}
+		}
+		if len(qs.flights) != 0 {
+			t.Errorf("finished flight was kept")
+		}
+	})
+
+	t.Run("cancel with the last waiter", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 2, 0)
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+		first := queryAsync(ctx1, qs, req)
+		qi := nextQuery(t, qs)
+		second := queryAsync(ctx2, qs, req)
+		waitForWaiters(t, qs, req, 2)
+
+		cancel1()
+		if got := <-first; !errors.Is(got.err, context.Canceled) {
+			t.Errorf("first query: got %v, want context.Canceled", got.err)
+		}
+		if qi.ctx.Err() != nil {
+			t.Fatalf("search was canceled while a waiter is left")
+		}
+
+		cancel2()
+		if got := <-second; !errors.Is(got.err, context.Canceled) {
+			t.Errorf("second query: got %v, want context.Canceled", got.err)
+		}
+		select {
+		case <-qi.ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("search was not canceled without waiters")
+		}
+	})
+
+	t.Run("late waiter", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 2, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		first := queryAsync(ctx, qs, req)
+		canceled := nextQuery(t, qs)
+		cancel()
+		<-first
+
+		// The canceled search has not finished yet, but an identical
+		// query must not join it.
+		late := queryAsync(context.Background(), qs, req)
+		qi := nextQuery(t, qs)
+		if qi.ctx.Err() != nil {
+			t.Fatalf("late query joined the canceled search")
+		}
+		canceled.fn(nil, canceled.ctx.Err())
+
+		want := &Result{FQIDs: []string{"motion/1"}}
+		waitForWaiters(t, qs, req, 1)
+		qi.fn(want, nil)
+		if got := <-late; got.err != nil || got.result != want {
+			t.Errorf("got %v, %v, want the result of the new search", got.result, got.err)
+		}
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	ti := newProposalIndex(t)
+	req := &Request{Question: "Haushalt", Size: 10}
+
+	t.Run("waiting", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, 10*time.Millisecond)
+		_, err := qs.Query(context.Background(), req)
+		var terr TimeoutError
+		if !errors.As(err, &terr) {
+			t.Fatalf("got %v, want a TimeoutError", err)
+		}
+		if got := terr.StatusCode(); got != http.StatusGatewayTimeout {
+			t.Errorf("status code = %d, want %d", got, http.StatusGatewayTimeout)
+		}
+	})
+
+	t.Run("searching", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, time.Minute)
+		done := queryAsync(context.Background(), qs, req)
+		qi := nextQuery(t, qs)
+		qi.fn(nil, context.DeadlineExceeded)
+		if got := <-done; !errors.As(got.err, new(TimeoutError)) {
+			t.Errorf("got %v, want a TimeoutError", got.err)
+		}
+	})
+
+	t.Run("client canceled", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := queryAsync(ctx, qs, req)
+		nextQuery(t, qs)
+		cancel()
+		got := <-done
+		if !errors.Is(got.err, context.Canceled) || errors.As(got.err, new(TimeoutError)) {
+			t.Errorf("got %v, want context.Canceled", got.err)
+		}
+	})
+
+	t.Run("client deadline", func(t *testing.T) {
+		qs := newTestQueryServer(t, ti, 1, 1, time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := qs.Query(ctx, req)
+		if !errors.Is(err, context.DeadlineExceeded) || errors.As(err, new(TimeoutError)) {
+			t.Errorf("got %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+func TestQueryQueueFull(t *testing.T) {
+	ti := newProposalIndex(t)
+	qs := newTestQueryServer(t, ti, 1, 1, time.Minute)
+
+	// Nobody works on the queue, so one item fills it.
+	qs.queries <- queryItem{}
+
+	_, err := qs.Query(context.Background(), &Request{Question: "Satzung", Size: 10})
+	var oerr OverloadError
+	if !errors.As(err, &oerr) {
+		t.Fatalf("got %v, want an OverloadError", err)
+	}
+	if got := oerr.StatusCode(); got != http.StatusServiceUnavailable {
+		t.Errorf("status code = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	if got := oerr.RetryAfter(); got != queueRetryAfter {
+		t.Errorf("retry after = %v, want %v", got, queueRetryAfter)
+	}
+	if got := qs.Rejected(); got != 1 {
+		t.Errorf("rejected = %d, want 1", got)
+	}
+
+	qs.flightsMu.Lock()
+	defer qs.flightsMu.Unlock()
+	if _, ok := qs.flights[(&Request{Question: "Satzung", Size: 10}).cacheKey()]; ok {
+		t.Errorf("rejected query is kept as flight")
+	}
+}
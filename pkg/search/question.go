@@ -0,0 +1,597 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/de"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Questions are parsed by this grammar:
+//
+//	question = { or } ;
+//	or       = and { "OR" and } ;
+//	and      = unary { "AND" unary } ;
+//	unary    = ( "NOT" | "-" | "+" ) unary | primary ;
+//	primary  = "(" question ")" | [ field ":" ] ( phrase | word ) ;
+//	phrase   = '"' { character } '"' ;
+//
+// The operators are case sensitive. Terms written next to each other
+// are optional but at least one of them has to match, unless some are
+// required by '+'. "NOT" and '-' exclude, "AND" requires both sides and
+// "OR" either side. A word is delimited by white space and parentheses.
+// '*' and '?' in words are wildcards, a backslash escapes the next
+// character. An unclosed phrase ends at the end of the question.
+
+// term is a word or phrase of a question, optionally restricted
+// to a field.
+type term struct {
+	// field is the name or alias of a field as written
+	// by the user. Empty for all fields.
+	field    string
+	text     string
+	phrase   bool
+	wildcard bool
+	// pattern is the regular expression of a wildcard term in
+	// which escaped wildcard characters match literally.
+	pattern string
+}
+
+// nodeKind is the kind of a node of a parsed question.
+type nodeKind int
+
+const (
+	termNode nodeKind = iota
+	// sequenceNode is a list of terms written next to each other.
+	sequenceNode
+	andNode
+	orNode
+	notNode
+	requiredNode
+)
+
+// node is a part of a parsed question.
+type node struct {
+	kind nodeKind
+	// term is only set for term nodes.
+	term     term
+	children []node
+}
+
+// tokenKind is the kind of a token of a question.
+type tokenKind int
+
+const (
+	endToken tokenKind = iota
+	termToken
+	openToken
+	closeToken
+	andToken
+	orToken
+	notToken
+	plusToken
+	minusToken
+)
+
+// token is a lexical unit of a question. pos is its offset
+// in characters.
+type token struct {
+	kind tokenKind
+	pos  int
+	term term
+}
+
+// operators are the words which are read as operators.
+var operators = map[string]tokenKind{
+	"AND": andToken,
+	"OR":  orToken,
+	"NOT": notToken,
+}
+
+// tokenize splits a question into tokens. The last one is an end token.
+func tokenize(question string) ([]token, error) {
+	var (
+		tokens []token
+		runes  = []rune(question)
+	)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, token{kind: openToken, pos: i})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, token{kind: closeToken, pos: i})
+			i++
+			continue
+		case r == '+' || r == '-':
+			// A lone sign is no operator.
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				kind := plusToken
+				if r == '-' {
+					kind = minusToken
+				}
+				tokens = append(tokens, token{kind: kind, pos: i})
+			}
+			i++
+			continue
+		}
+
+		t := token{kind: termToken, pos: i}
+		if name, n := fieldPrefix(runes[i:]); n > 0 {
+			t.term.field = name
+			i += n
+		}
+
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			t.term.text = strings.TrimSpace(string(runes[i+1 : end]))
+			t.term.phrase = true
+			i = end + 1
+		} else {
+			start := i
+			var text, pattern strings.Builder
+			for ; i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')'; i++ {
+				r := runes[i]
+				if r == '\\' && i+1 < len(runes) {
+					i++
+					text.WriteRune(runes[i])
+					pattern.WriteString(regexp.QuoteMeta(string(runes[i])))
+					continue
+				}
+				switch r {
+				case '*':
+					t.term.wildcard = true
+					pattern.WriteString(".*")
+				case '?':
+					t.term.wildcard = true
+					pattern.WriteString(".")
+				default:
+					pattern.WriteString(regexp.QuoteMeta(string(r)))
+				}
+				text.WriteRune(r)
+			}
+			t.term.text = text.String()
+			if t.term.wildcard {
+				t.term.pattern = pattern.String()
+			}
+
+			if kind, ok := operators[string(runes[start:i])]; ok && t.term.field == "" {
+				tokens = append(tokens, token{kind: kind, pos: t.pos})
+				continue
+			}
+		}
+
+		if t.term.text == "" {
+			if t.term.field != "" {
+				return nil, parseErrorf(t.pos, "missing word or phrase after %s:", t.term.field)
+			}
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	return append(tokens, token{kind: endToken, pos: len(runes)}), nil
+}
+
+// fieldPrefix returns the name of a leading "field:" and the number of
+// runes it takes. A name starts with a letter or underscore and has to
+// be followed by the searched text.
+func fieldPrefix(runes []rune) (string, int) {
+	for i, r := range runes {
+		switch {
+		case r == ':':
+			if i == 0 || i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				return "", 0
+			}
+			return string(runes[:i]), i + 1
+		case r == '_' || unicode.IsLetter(r) || i > 0 && unicode.IsDigit(r):
+		default:
+			return "", 0
+		}
+	}
+	return "", 0
+}
+
+// parser reads a question by recursive descent.
+type parser struct {
+	tokens []token
+}
+
+func (p *parser) peek() token {
+	return p.tokens[0]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[0]
+	if t.kind != endToken {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+// parseQuestion parses a question into a sequence node.
+func parseQuestion(question string) (node, error) {
+	tokens, err := tokenize(question)
+	if err != nil {
+		return node{}, err
+	}
+	p := parser{tokens: tokens}
+	n, err := p.question()
+	if err != nil {
+		return node{}, err
+	}
+	if t := p.peek(); t.kind != endToken {
+		return node{}, parseErrorf(t.pos, "unexpected closing parenthesis")
+	}
+	return n, nil
+}
+
+func (p *parser) question() (node, error) {
+	n := node{kind: sequenceNode}
+	for {
+		switch p.peek().kind {
+		case endToken, closeToken:
+			return n, nil
+		}
+		child, err := p.or()
+		if err != nil {
+			return node{}, err
+		}
+		n.children = append(n.children, child)
+	}
+}
+
+func (p *parser) or() (node, error) {
+	return p.binary(orToken, orNode, p.and)
+}
+
+func (p *parser) and() (node, error) {
+	return p.binary(andToken, andNode, p.unary)
+}
+
+// binary parses operands joined by the operator.
+func (p *parser) binary(op tokenKind, kind nodeKind, operand func() (node, error)) (node, error) {
+	first, err := operand()
+	if err != nil {
+		return node{}, err
+	}
+	n := node{kind: kind, children: []node{first}}
+	for p.peek().kind == op {
+		p.next()
+		child, err := operand()
+		if err != nil {
+			return node{}, err
+		}
+		n.children = append(n.children, child)
+	}
+	if len(n.children) == 1 {
+		return first, nil
+	}
+	return n, nil
+}
+
+func (p *parser) unary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case notToken, minusToken, plusToken:
+		operand, err := p.unary()
+		if err != nil {
+			return node{}, err
+		}
+		kind := notNode
+		if t.kind == plusToken {
+			kind = requiredNode
+		}
+		return node{kind: kind, children: []node{operand}}, nil
+
+	case openToken:
+		n, err := p.question()
+		if err != nil {
+			return node{}, err
+		}
+		if p.next().kind != closeToken {
+			return node{}, parseErrorf(t.pos, "missing closing parenthesis")
+		}
+		if len(n.children) == 0 {
+			return node{}, parseErrorf(t.pos, "empty parentheses")
+		}
+		return n, nil
+
+	case termToken:
+		return node{kind: termNode, term: t.term}, nil
+
+	case andToken:
+		return node{}, parseErrorf(t.pos, "missing term before AND")
+	case orToken:
+		return node{}, parseErrorf(t.pos, "missing term before OR")
+	case closeToken:
+		return node{}, parseErrorf(t.pos, "unexpected closing parenthesis")
+	default:
+		return node{}, parseErrorf(t.pos, "unexpected end of question")
+	}
+}
+
+// fieldKind tells how a field is searched.
+type fieldKind int
+
+const (
+	// analyzedField is a text field searched by its analyzed terms.
+	analyzedField fieldKind = iota
+	// originalField is an analyzed text field with an additional
+	// dictionary of unstemmed terms.
+	originalField
+	// numericField is searched by equal numbers.
+	numericField
+	// keywordField is searched by its exact value.
+	keywordField
+)
+
+// queryField is a field which can be named in questions.
+type queryField struct {
+	name string
+	kind fieldKind
+}
+
+// newQueryField returns how the given searchable field is searched.
+func newQueryField(fname string, f *meta.Member) queryField {
+	if f.Analyzer != nil {
+		return queryField{name: fname, kind: analyzedField}
+	}
+	switch f.Type {
+	case "string", "text":
+		return queryField{name: fname, kind: originalField}
+	case "generic-relation":
+		return queryField{name: fname, kind: keywordField}
+	case "relation", "relation-list", "number", "number[]":
+		return queryField{name: fname, kind: numericField}
+	default:
+		return queryField{name: fname, kind: analyzedField}
+	}
+}
+
+// queryFields maps the lower cased names and aliases of the searchable
+// fields to the fields they stand for.
+func queryFields(collections meta.Collections) map[string][]queryField {
+	names := map[string]map[queryField]struct{}{}
+	add := func(name string, qf queryField) {
+		name = strings.ToLower(name)
+		if names[name] == nil {
+			names[name] = map[queryField]struct{}{}
+		}
+		names[name][qf] = struct{}{}
+	}
+	for _, col := range collections {
+		for fname, f := range col.Fields {
+			if !f.Searchable {
+				continue
+			}
+			qf := newQueryField(fname, f)
+			add(fname, qf)
+			for _, alias := range f.Aliases {
+				add(alias, qf)
+			}
+		}
+	}
+
+	fields := make(map[string][]queryField, len(names))
+	for name, qfs := range names {
+		for qf := range qfs {
+			fields[name] = append(fields[name], qf)
+		}
+		sort.Slice(fields[name], func(i, j int) bool {
+			a, b := fields[name][i], fields[name][j]
+			if a.name != b.name {
+				return a.name < b.name
+			}
+			return a.kind < b.kind
+		})
+	}
+	return fields
+}
+
+// query returns the query for the term in the field.
+// Returns nil if the field can not contain the text.
+func (qf queryField) query(t term) query.BoostableQuery {
+	switch qf.kind {
+	case numericField:
+		num, err := strconv.ParseFloat(t.text, 64)
+		if err != nil || t.wildcard {
+			return nil
+		}
+		numericQuery := newNumericQuery(num)
+		numericQuery.SetField(qf.name)
+		return numericQuery
+
+	case keywordField:
+		if t.wildcard {
+			wildcardQuery := bleve.NewRegexpQuery(t.pattern)
+			wildcardQuery.SetField(qf.name)
+			return wildcardQuery
+		}
+		termQuery := bleve.NewTermQuery(t.text)
+		termQuery.SetField(qf.name)
+		return termQuery
+	}
+
+	switch {
+	case t.phrase:
+		phraseQuery := bleve.NewMatchPhraseQuery(t.text)
+		phraseQuery.SetField(qf.name)
+		return phraseQuery
+
+	case t.wildcard:
+		// Wildcards are matched against unstemmed terms if possible.
+		wildcardQuery := bleve.NewRegexpQuery(strings.ToLower(t.pattern))
+		wildcardQuery.SetField(qf.name)
+		if qf.kind == originalField {
+			wildcardQuery.SetField("_" + qf.name + "_original")
+		}
+		return wildcardQuery
+	}
+
+	matchQuery := bleve.NewMatchQuery(t.text)
+	matchQuery.SetField(qf.name)
+	if qf.kind != originalField {
+		return matchQuery
+	}
+	originalQuery := bleve.NewMatchQuery(t.text)
+	originalQuery.SetField("_" + qf.name + "_original")
+	return bleve.NewDisjunctionQuery(matchQuery, originalQuery)
+}
+
+// exactQuery translates a term into a query. Fails if the term
+// names an unknown field or a field which can not contain its text.
+func (ti *TextIndex) exactQuery(t term) (query.BoostableQuery, error) {
+	if t.field != "" {
+		fields, ok := ti.queryFields[strings.ToLower(t.field)]
+		if !ok {
+			return nil, requestErrorf("unknown field %q", t.field)
+		}
+		queries := make([]query.Query, 0, len(fields))
+		for _, qf := range fields {
+			if q := qf.query(t); q != nil {
+				queries = append(queries, q)
+			}
+		}
+		if len(queries) == 0 {
+			return nil, requestErrorf("field %q can not contain %q", t.field, t.text)
+		}
+		return bleve.NewDisjunctionQuery(queries...), nil
+	}
+
+	switch {
+	case t.phrase:
+		return bleve.NewMatchPhraseQuery(t.text), nil
+	case t.wildcard:
+		return bleve.NewRegexpQuery(strings.ToLower(t.pattern)), nil
+	}
+	matchQuery := bleve.NewMatchQuery(t.text)
+	num, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return matchQuery, nil
+	}
+	return bleve.NewDisjunctionQuery(matchQuery, newNumericQuery(num)), nil
+}
+
+// exactBoost scores exact hits above hits of parts of words and typos.
+const exactBoost = 5
+
+// questionQuery parses a question and translates it into a query.
+func (ti *TextIndex) questionQuery(question string) (query.Query, error) {
+	root, err := parseQuestion(question)
+	if err != nil {
+		return nil, err
+	}
+	return ti.nodeQuery(root, true)
+}
+
+// nodeQuery translates a node into a query. Loose words, which are
+// neither excluded nor restricted to a field nor wildcards, also match
+// as part of words and with typos.
+func (ti *TextIndex) nodeQuery(n node, loose bool) (query.Query, error) {
+	switch n.kind {
+	case termNode:
+		return ti.termQuery(n.term, loose)
+
+	case requiredNode:
+		return ti.nodeQuery(n.children[0], loose)
+
+	case notNode:
+		excluded, err := ti.nodeQuery(n.children[0], false)
+		if err != nil {
+			return nil, err
+		}
+		// A lone exclusion matches all other documents.
+		return query.NewBooleanQueryForQueryString(nil, nil, []query.Query{excluded}), nil
+
+	case orNode:
+		queries := make([]query.Query, len(n.children))
+		for i, child := range n.children {
+			q, err := ti.nodeQuery(child, loose)
+			if err != nil {
+				return nil, err
+			}
+			queries[i] = q
+		}
+		return bleve.NewDisjunctionQuery(queries...), nil
+	}
+
+	// And and sequence nodes differ only in their plain children.
+	var must, should, mustNot []query.Query
+	for _, child := range n.children {
+		switch child.kind {
+		case notNode:
+			q, err := ti.nodeQuery(child.children[0], false)
+			if err != nil {
+				return nil, err
+			}
+			mustNot = append(mustNot, q)
+		case requiredNode:
+			q, err := ti.nodeQuery(child.children[0], loose)
+			if err != nil {
+				return nil, err
+			}
+			must = append(must, q)
+		default:
+			q, err := ti.nodeQuery(child, loose)
+			if err != nil {
+				return nil, err
+			}
+			if n.kind == andNode {
+				must = append(must, q)
+			} else {
+				should = append(should, q)
+			}
+		}
+	}
+	if len(must)+len(should) == 1 && len(mustNot) == 0 {
+		return append(must, should...)[0], nil
+	}
+	return query.NewBooleanQueryForQueryString(must, should, mustNot), nil
+}
+
+// termQuery translates a term into a query.
+func (ti *TextIndex) termQuery(t term, loose bool) (query.Query, error) {
+	q, err := ti.exactQuery(t)
+	if err != nil {
+		return nil, err
+	}
+	q.SetBoost(exactBoost)
+	if !loose || t.field != "" || t.phrase || t.wildcard {
+		return q, nil
+	}
+	// Stop words match nothing and are skipped by the enclosing
+	// query. They must not be required as part of words instead.
+	if analyzer := ti.indexMapping.AnalyzerNamed(de.AnalyzerName); analyzer != nil &&
+		len(analyzer.Analyze([]byte(t.text))) == 0 {
+		return q, nil
+	}
+
+	looseQuery := bleve.NewDisjunctionQuery(q)
+	if utf8.RuneCountInString(t.text) > 2 {
+		looseQuery.AddQuery(bleve.NewRegexpQuery(".*" + regexp.QuoteMeta(strings.ToLower(t.text)) + ".*"))
+	}
+	if fuzzyQuery := ti.fuzzyQuery(t.text); fuzzyQuery != nil {
+		looseQuery.AddQuery(fuzzyQuery)
+	}
+	return looseQuery, nil
+}
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// dumpNode writes a parsed question as an s-expression.
+func dumpNode(n node) string {
+	switch n.kind {
+	case termNode:
+		var b strings.Builder
+		if n.term.field != "" {
+			b.WriteString(n.term.field + ":")
+		}
+		if n.term.phrase {
+			b.WriteString(fmt.Sprintf("%q", n.term.text))
+		} else {
+			b.WriteString(n.term.text)
+		}
+		if n.term.wildcard {
+			b.WriteString("~/" + n.term.pattern + "/")
+		}
+		return b.String()
+	}
+
+	names := map[nodeKind]string{
+		sequenceNode: "seq",
+		andNode:      "and",
+		orNode:       "or",
+		notNode:      "not",
+		requiredNode: "req",
+	}
+	parts := []string{names[n.kind]}
+	for _, child := range n.children {
+		parts = append(parts, dumpNode(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestParseQuestion(t *testing.T) {
+	for _, tt := range []struct {
+		question string
+		want     string
+	}{
+		{"", "(seq)"},
+		{"   ", "(seq)"},
+		{"Haushalt", "(seq Haushalt)"},
+		{"Haushalt 2024", "(seq Haushalt 2024)"},
+		{`"der Haushalt" 2024`, `(seq "der Haushalt" 2024)`},
+		{`"unclosed phrase`, `(seq "unclosed phrase")`},
+		{`""`, "(seq)"},
+
+		// Operators.
+		{"a AND b", "(seq (and a b))"},
+		{"a OR b", "(seq (or a b))"},
+		{"a AND b OR c", "(seq (or (and a b) c))"},
+		{"a OR b AND c", "(seq (or a (and b c)))"},
+		{"a AND b AND c", "(seq (and a b c))"},
+		{"a b OR c", "(seq a (or b c))"},
+		{"(a OR b) AND c", "(seq (and (seq (or a b)) c))"},
+		{"a AND (b c)", "(seq (and a (seq b c)))"},
+		{"and or not", "(seq and or not)"},
+
+		// Exclusions and required terms.
+		{"NOT a", "(seq (not a))"},
+		{"-a", "(seq (not a))"},
+		{"NOT NOT a", "(seq (not (not a)))"},
+		{"NOT (a OR b)", "(seq (not (seq (or a b))))"},
+		{"a AND NOT b", "(seq (and a (not b)))"},
+		{"+a -b c", "(seq (req a) (not b) c)"},
+		{"-(a b)", "(seq (not (seq a b)))"},
+		{"a - b", "(seq a b)"},
+		{"Haus-halt", "(seq Haus-halt)"},
+
+		// Wildcards and escapes.
+		{"Haus*", "(seq Haus*~/Haus.*/)"},
+		{"H?us", "(seq H?us~/H.us/)"},
+		{`a\*b*`, `(seq a*b*~/a\*b.*/)`},
+		{`a\*b`, `(seq a*b)`},
+		{`a.b*`, `(seq a.b*~/a\.b.*/)`},
+		{`\AND`, "(seq AND)"},
+		{`\(a\)`, "(seq (a))"},
+		{`a\ b`, "(seq a b)"},
+
+		// Fields.
+		{"title:Haushalt", "(seq title:Haushalt)"},
+		{`title:"der Haushalt"`, `(seq title:"der Haushalt")`},
+		{"title:Haus*", "(seq title:Haus*~/Haus.*/)"},
+		{"-title:Haushalt", "(seq (not title:Haushalt))"},
+		{"title:AND", "(seq title:AND)"},
+		{"12:30", "(seq 12:30)"},
+		{"title:", "(seq title:)"},
+		{"title: Haushalt", "(seq title: Haushalt)"},
+		{`title\:Haushalt`, "(seq title:Haushalt)"},
+		{"sequential_number:12", "(seq sequential_number:12)"},
+	} {
+		t.Run(tt.question, func(t *testing.T) {
+			got, err := parseQuestion(tt.question)
+			if err != nil {
+				t.Fatalf("parseQuestion(%q): %v", tt.question, err)
+			}
+			if dump := dumpNode(got); dump != tt.want {
+				t.Errorf("parseQuestion(%q) = %s, want %s", tt.question, dump, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuestionFieldIsNotEscaped(t *testing.T) {
+	got, err := parseQuestion(`title\:Haushalt`)
+	if err != nil {
+		t.Fatalf("parseQuestion: %v", err)
+	}
+	if term := got.children[0].term; term.field != "" {
+		t.Errorf("got field %q, want none", term.field)
+	}
+}
+
+func TestParseQuestionErrors(t *testing.T) {
+	for _, tt := range []struct {
+		question string
+		msg      string
+		pos      int
+	}{
+		{"a AND", "unexpected end of question", 5},
+		{"a OR", "unexpected end of question", 4},
+		{"NOT", "unexpected end of question", 3},
+		{"a AND NOT", "unexpected end of question", 9},
+		{"AND a", "missing term before AND", 0},
+		{"a OR OR b", "missing term before OR", 5},
+		{"a AND OR b", "missing term before OR", 6},
+		{"(a b", "missing closing parenthesis", 0},
+		{"a (b (c)", "missing closing parenthesis", 2},
+		{"a)", "unexpected closing parenthesis", 1},
+		{"(a))", "unexpected closing parenthesis", 3},
+		{"()", "empty parentheses", 0},
+		{"a AND ( )", "empty parentheses", 6},
+		{"a AND )", "unexpected closing parenthesis", 6},
+		{"title:(a)", "missing word or phrase after title:", 0},
+		{`x title:""`, "missing word or phrase after title:", 2},
+		// Positions are counted in characters.
+		{"Über AND", "unexpected end of question", 8},
+	} {
+		t.Run(tt.question, func(t *testing.T) {
+			_, err := parseQuestion(tt.question)
+			var perr ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("parseQuestion(%q) = %v, want a ParseError", tt.question, err)
+			}
+			if perr.msg != tt.msg || perr.Position != tt.pos {
+				t.Errorf("parseQuestion(%q) = %q at %d, want %q at %d",
+					tt.question, perr.msg, perr.Position, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+func TestParseErrorDetails(t *testing.T) {
+	err := parseErrorf(7, "missing closing parenthesis")
+	if err.Type() != "invalid_query" {
+		t.Errorf("type = %q, want invalid_query", err.Type())
+	}
+	if want := map[string]any{"position": 7}; !reflect.DeepEqual(err.Details(), want) {
+		t.Errorf("details = %v, want %v", err.Details(), want)
+	}
+	if want := "missing closing parenthesis at position 7"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestSearchQuestion(t *testing.T) {
+	collections := meta.Collections{
+		"motion": {Fields: map[string]*meta.Member{
+			"title":             {Type: "string", Searchable: true, Aliases: []string{"titel"}},
+			"text":              {Type: "HTMLStrict", Searchable: true},
+			"sequential_number": {Type: "number", Searchable: true, Aliases: []string{"nr"}},
+		}},
+	}
+	cfg := &config.Config{}
+	cfg.Fuzzy.Distance = 2
+	cfg.Fuzzy.MinLength = 5
+	ti, err := NewTextIndex(cfg, nil, collections)
+	if err != nil {
+		t.Fatalf("creating text index: %v", err)
+	}
+	index, err := bleve.New(t.TempDir()+"/index", ti.indexMapping)
+	if err != nil {
+		t.Fatalf("creating index: %v", err)
+	}
+	defer index.Close()
+	ti.use(index, "")
+
+	for _, d := range []struct {
+		fqid, title, text string
+		number            float64
+	}{
+		{"motion/1", "Haushalt 2024", "<p>Der Antrag über Geld</p>", 12},
+		{"motion/2", "Satzung", "<p>Haushalt ist wichtig</p>", 13},
+		{"motion/3", "Gartenhaus", "<p>Ein Garten</p>", 14},
+		{"motion/4", "ab Regel", "", 15},
+		{"motion/5", "axxb Regel", "", 16},
+	} {
+		doc := newBleveType("motion")
+		doc["title"] = d.title
+		doc["_title_original"] = d.title
+		doc["text"] = d.text
+		doc["sequential_number"] = d.number
+		if err := index.Index(d.fqid, doc); err != nil {
+			t.Fatalf("indexing: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		question string
+		want     []string
+	}{
+		{"Haushalt", []string{"motion/1", "motion/2"}},
+		{"title:Haushalt", []string{"motion/1"}},
+		{"Titel:haus*", []string{"motion/1"}},
+		{"nr:13", []string{"motion/2"}},
+		{`text:"ist wichtig"`, []string{"motion/2"}},
+		{"Haushalt -Satzung", []string{"motion/1"}},
+		{"Haushalt NOT Satzung", []string{"motion/1"}},
+		{"Haushlat AND Geld", []string{"motion/1"}},
+		{"Satzung OR Garten", []string{"motion/2", "motion/3"}},
+		{"(Satzung OR Garten) AND NOT nr:14", []string{"motion/2"}},
+		{"NOT (Satzung OR Garten OR Regel)", []string{"motion/1"}},
+		{"+die +Satzung", []string{"motion/2"}},
+		{`title:a*b`, []string{"motion/4", "motion/5"}},
+		// Analyzed fields never contain a literal "*".
+		{`title:a\*b*`, nil},
+	} {
+		t.Run(tt.question, func(t *testing.T) {
+			result, err := ti.Search(context.Background(), &Request{Question: tt.question, Size: 10})
+			if err != nil {
+				t.Fatalf("searching: %v", err)
+			}
+			var got []string
+			got = append(got, result.FQIDs...)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, question := range []string{"foo:bar", "nr:abc"} {
+		if _, err := ti.Search(context.Background(), &Request{Question: question, Size: 10}); !errors.As(err, new(RequestError)) {
+			t.Errorf("searching %q: got %v, want a RequestError", question, err)
+		}
+	}
+}
+
+func TestQueryFields(t *testing.T) {
+	collections := meta.Collections{
+		"motion": {Fields: map[string]*meta.Member{
+			"title":             {Type: "string", Searchable: true, Aliases: []string{"Titel", "inhalt"}},
+			"text":              {Type: "HTMLStrict", Searchable: true, Aliases: []string{"inhalt"}},
+			"sequential_number": {Type: "number", Searchable: true, Aliases: []string{"Nr"}},
+			"owner_id":          {Type: "generic-relation", Searchable: true},
+			"reason":            {Type: "HTMLStrict", Aliases: []string{"begruendung"}},
+		}},
+		"topic": {Fields: map[string]*meta.Member{
+			"title": {Type: "string", Searchable: true, Aliases: []string{"titel"}},
+			"text":  {Type: "HTMLStrict", Searchable: true, Aliases: []string{"Inhalt"}},
+		}},
+	}
+
+	want := map[string][]queryField{
+		"title":             {{"title", originalField}},
+		"titel":             {{"title", originalField}},
+		"text":              {{"text", analyzedField}},
+		"inhalt":            {{"text", analyzedField}, {"title", originalField}},
+		"sequential_number": {{"sequential_number", numericField}},
+		"nr":                {{"sequential_number", numericField}},
+		"owner_id":          {{"owner_id", keywordField}},
+	}
+	if got := queryFields(collections); !reflect.DeepEqual(got, want) {
+		t.Errorf("queryFields() = %v, want %v", got, want)
+	}
+}
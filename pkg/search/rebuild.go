@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+)
+
+// ErrRebuildRunning is returned if a rebuild is requested
+// while another one is running.
+var ErrRebuildRunning = errors.New("a rebuild of the text index is already running")
+
+// Rebuild starts building a fresh index in the background. Queries
+// are answered by the current index until the new one replaces it.
+func (ti *TextIndex) Rebuild() error {
+	ti.mu.RLock()
+	collections := ti.collections
+	ti.mu.RUnlock()
+
+	return ti.startRebuild(collections, nil)
+}
+
+// Reload replaces the indexed collections. If they are indexed
+// differently than before the index is rebuilt in the background.
+// swapped is called when the new collections are used.
+func (ti *TextIndex) Reload(collections meta.Collections, swapped func()) error {
+	if !ti.ready.Load() {
+		return ErrIndexBuilding
+	}
+
+	ti.mu.RLock()
+	current, err := ti.fingerprint()
+	ti.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	next, err := fingerprint(buildIndexMapping(collections), collections)
+	if err != nil {
+		return err
+	}
+
+	if current != next {
+		return ti.startRebuild(collections, swapped)
+	}
+
+	// The fields to request may have changed nevertheless.
+	ti.mu.Lock()
+	ti.setCollections(collections)
+	if swapped != nil {
+		swapped()
+	}
+	ti.mu.Unlock()
+	return nil
+}
+
+// startRebuild runs a rebuild in the background
+// unless another one is running.
+func (ti *TextIndex) startRebuild(collections meta.Collections, swapped func()) error {
+	if !ti.ready.Load() {
+		return ErrIndexBuilding
+	}
+	if !ti.rebuilding.CompareAndSwap(false, true) {
+		return ErrRebuildRunning
+	}
+
+	go func() {
+		defer ti.rebuilding.Store(false)
+		if err := ti.rebuild(collections, swapped); err != nil {
+			log.Errorf("rebuilding text index failed: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Rebuilding tells if a rebuild is running.
+func (ti *TextIndex) Rebuilding() bool {
+	return ti.rebuilding.Load()
+}
+
+// rebuild builds a new index of the given collections in a new
+// directory and swaps it with the current one. swapped is called
+// while still holding the lock after swapping.
+func (ti *TextIndex) rebuild(collections meta.Collections, swapped func()) error {
+	start := time.Now()
+
+	dir := ti.rebuiltIndexDir()
+	db := NewDatabase(ti.cfg)
+	indexMapping := buildIndexMapping(collections)
+
+	index, err := buildIndex(dir, ti.cfg.Index.Batch, db, collections, indexMapping)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	discard := func() {
+		index.Close()
+		os.RemoveAll(dir)
+	}
+
+	// Catch up before locking to keep the time short
+	// in which the index can not be searched.
+	if _, err := writeEvents(index, collections, ti.cfg.Index.Batch, db.forceUpdate); err != nil {
+		discard()
+		return fmt.Errorf("catching up with database failed: %w", err)
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if ti.index == nil {
+		discard()
+		return errIndexClosed
+	}
+	if _, err := writeEvents(index, collections, ti.cfg.Index.Batch, db.forceUpdate); err != nil {
+		discard()
+		return fmt.Errorf("catching up with database failed: %w", err)
+	}
+
+	old, oldDir := ti.index, ti.dir
+	ti.alias.Swap([]bleve.Index{index}, []bleve.Index{old})
+	ti.index, ti.dir, ti.db = index, dir, db
+	ti.fillDB.Store(db)
+	ti.indexMapping = indexMapping
+	ti.setCollections(collections)
+	ti.gen.Add(1)
+	ti.lastUpdate.Store(time.Now().UnixNano())
+	if swapped != nil {
+		swapped()
+	}
+
+	if err := old.Close(); err != nil {
+		log.Warnf("closing replaced index failed: %v\n", err)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		log.Warnf("removing replaced index %q failed: %v\n", oldDir, err)
+	}
+
+	log.Infof("rebuilding text index took %v\n", time.Since(start))
+	return nil
+}
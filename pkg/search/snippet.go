@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/document"
+	"github.com/blevesearch/bleve/v2/mapping"
+	bleveSearch "github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/highlight"
+	htmlFormat "github.com/blevesearch/bleve/v2/search/highlight/format/html"
+	simpleFragmenter "github.com/blevesearch/bleve/v2/search/highlight/fragmenter/simple"
+	simpleHighlighter "github.com/blevesearch/bleve/v2/search/highlight/highlighter/simple"
+)
+
+const (
+	// snippetSize is the approximate length in characters of a snippet.
+	snippetSize = 160
+	// maxSnippets is the maximum number of snippets per field.
+	maxSnippets = 3
+)
+
+// highlighter builds the snippets out of the text the terms were found
+// in. bleve highlights the stored values of the hits, but the locations
+// of terms of HTML fields are offsets into the text after the char filters
+// of the analyzer stripped the markup. So the highlighter is given the
+// filtered text instead.
+var highlighter = simpleHighlighter.NewHighlighter(
+	wordFragmenter{simpleFragmenter.NewFragmenter(snippetSize)},
+	htmlFormat.NewFragmentFormatter("<mark>", "</mark>"),
+	simpleHighlighter.DefaultSeparator,
+)
+
+// textFields returns the names of the fields snippets can be built of.
+func textFields(collections meta.Collections) []string {
+	names := map[string]struct{}{}
+	for _, col := range collections {
+		for fname, f := range col.Fields {
+			if f.Searchable && isTextField(f) {
+				names[fname] = struct{}{}
+			}
+		}
+	}
+	fields := make([]string, 0, len(names))
+	for fname := range names {
+		fields = append(fields, fname)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func isTextField(f *meta.Member) bool {
+	switch f.Type {
+	case "string", "text", "HTMLStrict", "HTMLPermissive":
+		return true
+	}
+	return false
+}
+
+// wordFragmenter moves the bounds of the fragments of another fragmenter
+// to the nearest white space inside them, so no word is cut.
+type wordFragmenter struct {
+	highlight.Fragmenter
+}
+
+func (f wordFragmenter) Fragment(orig []byte, locations highlight.TermLocations) []*highlight.Fragment {
+	fragments := f.Fragmenter.Fragment(orig, locations)
+	for _, fragment := range fragments {
+		// The terms of the fragment must stay inside.
+		first, last := fragment.End, fragment.Start
+		for _, l := range locations {
+			if l.Start >= fragment.Start && l.End <= fragment.End {
+				first, last = min(first, l.Start), max(last, l.End)
+			}
+		}
+		if first > last {
+			continue
+		}
+		if r, _ := utf8.DecodeLastRune(orig[:fragment.Start]); fragment.Start > 0 && !unicode.IsSpace(r) {
+			if i := bytes.IndexFunc(orig[fragment.Start:first], unicode.IsSpace); i >= 0 {
+				fragment.Start += i
+			}
+		}
+		if r, _ := utf8.DecodeRune(orig[fragment.End:]); fragment.End < len(orig) && !unicode.IsSpace(r) {
+			if i := bytes.LastIndexFunc(orig[last:fragment.End], unicode.IsSpace); i >= 0 {
+				_, size := utf8.DecodeRune(orig[last+i:])
+				fragment.End = last + i + size
+			}
+		}
+	}
+	return fragments
+}
+
+// charFilters returns the char filters of the analyzer of a field of a
+// collection. The term locations of the field are offsets into its value
+// after these filters.
+func (ti *TextIndex) charFilters(col, field string) []analysis.CharFilter {
+	im, ok := ti.indexMapping.(*mapping.IndexMappingImpl)
+	if !ok {
+		return nil
+	}
+	dm := im.TypeMapping[col]
+	if dm == nil {
+		return nil
+	}
+	property := dm.Properties[field]
+	if property == nil || len(property.Fields) == 0 || property.Fields[0].Analyzer == "" {
+		return nil
+	}
+	analyzer, ok := im.AnalyzerNamed(property.Fields[0].Analyzer).(*analysis.DefaultAnalyzer)
+	if !ok {
+		return nil
+	}
+	return analyzer.CharFilters
+}
+
+// filterText applies char filters to a value.
+func filterText(value string, filters []analysis.CharFilter) []byte {
+	text := []byte(value)
+	for _, filter := range filters {
+		text = filter.Filter(text)
+	}
+	return text
+}
+
+// snippets returns the highlighted fragments per field of a hit.
+func (ti *TextIndex) snippets(hit *bleveSearch.DocumentMatch) map[string][]string {
+	col, _, _ := strings.Cut(hit.ID, "/")
+	mcol := ti.collections[col]
+	if mcol == nil {
+		return nil
+	}
+
+	// Merge the locations of the original fields into their base fields.
+	// Their offsets only agree if both are filtered the same way.
+	fieldTerms := map[string]bleveSearch.TermLocationMap{}
+	for location, terms := range hit.Locations {
+		fname := location
+		if base, ok := strings.CutPrefix(location, "_"); ok {
+			if base, ok = strings.CutSuffix(base, "_original"); ok {
+				fname = base
+			}
+		}
+		if f := mcol.Fields[fname]; f == nil || !isTextField(f) {
+			continue
+		}
+		if fname != location && !sameCharFilters(ti.charFilters(col, fname), ti.charFilters(col, location)) {
+			continue
+		}
+		if fieldTerms[fname] == nil {
+			fieldTerms[fname] = bleveSearch.TermLocationMap{}
+		}
+		for term, locations := range terms {
+			fieldTerms[fname][term] = appendLocations(fieldTerms[fname][term], locations)
+		}
+	}
+
+	result := map[string][]string{}
+	for fname, terms := range fieldTerms {
+		filters := ti.charFilters(col, fname)
+		doc := document.NewDocument(hit.ID)
+		switch v := hit.Fields[fname].(type) {
+		case string:
+			doc.AddField(document.NewTextField(fname, nil, filterText(v, filters)))
+		case []any:
+			for pos, e := range v {
+				s, _ := e.(string)
+				doc.AddField(document.NewTextField(fname, []uint64{uint64(pos)}, filterText(s, filters)))
+			}
+		default:
+			continue
+		}
+
+		match := &bleveSearch.DocumentMatch{
+			Locations: bleveSearch.FieldTermLocationMap{fname: terms},
+		}
+		fragments := highlighter.BestFragmentsInField(match, doc, fname, maxSnippets)
+		for _, fragment := range fragments {
+			// Blanked markup leaves runs of white space.
+			result[fname] = append(result[fname], strings.Join(strings.Fields(fragment), " "))
+		}
+	}
+	return result
+}
+
+// appendLocations adds the locations which are not yet contained.
+func appendLocations(locations, other bleveSearch.Locations) bleveSearch.Locations {
+outer:
+	for _, l := range other {
+		for _, known := range locations {
+			if known.Start == l.Start && known.End == l.End && known.ArrayPositions.Equals(l.ArrayPositions) {
+				continue outer
+			}
+		}
+		locations = append(locations, l)
+	}
+	return locations
+}
+
+// sameCharFilters reports whether two analyzers filter a text the same way.
+func sameCharFilters(a, b []analysis.CharFilter) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
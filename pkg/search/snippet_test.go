@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// hitSnippets indexes the document as motion/1 and returns the snippets
+// of it as hit of the match query.
+var simpleAnalyzer = "simple"
+
+func hitSnippets(t *testing.T, doc bleveType, match string) map[string][]string {
+	t.Helper()
+	collections := meta.Collections{
+		"motion": {Fields: map[string]*meta.Member{
+			"title":    {Type: "string", Searchable: true},
+			"text":     {Type: "HTMLStrict", Searchable: true},
+			"keywords": {Type: "string", Searchable: true, Analyzer: &simpleAnalyzer},
+		}},
+	}
+	ti, err := NewTextIndex(nil, nil, collections)
+	if err != nil {
+		t.Fatalf("creating text index: %v", err)
+	}
+	index, err := bleve.New(t.TempDir()+"/index", ti.indexMapping)
+	if err != nil {
+		t.Fatalf("creating index: %v", err)
+	}
+	defer index.Close()
+	ti.use(index, "")
+
+	if err := index.Index("motion/1", doc); err != nil {
+		t.Fatalf("indexing: %v", err)
+	}
+
+	request := bleve.NewSearchRequest(bleve.NewMatchQuery(match))
+	request.IncludeLocations = true
+	request.Fields = ti.textFields
+	result, err := index.Search(request)
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+	if len(result.Hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(result.Hits))
+	}
+	return ti.snippets(result.Hits[0])
+}
+
+func TestSnippets(t *testing.T) {
+	long := strings.Repeat("wort ", 60)
+
+	for _, tt := range []struct {
+		name  string
+		field string
+		value any
+		match string
+		want  []string
+	}{
+		{
+			"plain text",
+			"title",
+			"Der Haushalt 2024",
+			"Haushalt",
+			[]string{"Der <mark>Haushalt</mark> 2024"},
+		},
+		{
+			"plain text is escaped",
+			"title",
+			"a < b & <i>Haushalt</i>",
+			"Haushalt",
+			[]string{"a &lt; b &amp; &lt;i&gt;<mark>Haushalt</mark>&lt;/i&gt;"},
+		},
+		{
+			"stemmed and original terms",
+			"title",
+			"Die Haushalte",
+			"Haushalte",
+			[]string{"Die <mark>Haushalte</mark>"},
+		},
+		{
+			// The entities shift the offsets of the terms behind them.
+			"markup is stripped",
+			"text",
+			"<p>Tom &amp; Jerry &uuml;ber den <b>Haushalt</b></p>",
+			"Haushalt",
+			[]string{"Tom &amp; Jerry über den <mark>Haushalt</mark>"},
+		},
+		{
+			"escaped markup",
+			"text",
+			"<p>&lt;b&gt; Haushalt</p>",
+			"Haushalt",
+			[]string{"&lt;b&gt; <mark>Haushalt</mark>"},
+		},
+		{
+			"two matches in one fragment",
+			"title",
+			"Haushalt und Haushalt",
+			"Haushalt",
+			[]string{"<mark>Haushalt</mark> und <mark>Haushalt</mark>"},
+		},
+		{
+			"ellipsis in a long text",
+			"text",
+			"<p>" + long + "<b>Haushalt</b> " + long + "</p>",
+			"Haushalt",
+			nil,
+		},
+		{
+			"array values",
+			"keywords",
+			[]any{"Garten", "Haushalt Plan"},
+			"haushalt",
+			[]string{"<mark>Haushalt</mark> Plan"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newBleveType("motion")
+			doc[tt.field] = tt.value
+			if tt.field == "title" {
+				doc["_title_original"] = tt.value
+			}
+
+			got := hitSnippets(t, doc, tt.match)[tt.field]
+			if tt.want == nil {
+				if len(got) != 1 || !strings.HasPrefix(got[0], "… ") || !strings.HasSuffix(got[0], " …") ||
+					!strings.Contains(got[0], "<mark>Haushalt</mark>") || len([]rune(got[0])) > snippetSize+10 {
+					t.Errorf("got %q, want one shortened snippet", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetsLimit(t *testing.T) {
+	doc := newBleveType("motion")
+	doc["text"] = strings.Repeat("<p>"+strings.Repeat("wort ", 60)+"Haushalt</p>", 5)
+
+	got := hitSnippets(t, doc, "Haushalt")["text"]
+	if len(got) != maxSnippets {
+		t.Fatalf("got %d snippets, want %d: %q", len(got), maxSnippets, got)
+	}
+	for _, s := range got {
+		if !strings.Contains(s, "<mark>Haushalt</mark>") {
+			t.Errorf("snippet %q misses the match", s)
+		}
+	}
+}
+
+func TestSnippetsHideOtherFields(t *testing.T) {
+	doc := newBleveType("motion")
+	doc["title"] = "Antrag"
+	doc["_title_original"] = "Antrag"
+	doc["text"] = "<p>Haushalt</p>"
+
+	want := map[string][]string{"text": {"<mark>Haushalt</mark>"}}
+	if got := hitSnippets(t, doc, "Haushalt"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Status describes the state of the text index.
+type Status struct {
+	// State is one of "building", "ready" or "rebuilding".
+	State string `json:"state"`
+	// Progress of building the index in percent.
+	Progress float64 `json:"progress"`
+	// Generation of the database is advanced by every full
+	// comparison of the index with the database.
+	Generation uint16 `json:"generation"`
+	// IndexGeneration changes with every change of the index.
+	IndexGeneration uint64                      `json:"index_generation"`
+	LastUpdate      time.Time                   `json:"last_update"`
+	Index           string                      `json:"index,omitempty"`
+	IndexSize       int64                       `json:"index_size"`
+	Documents       int                         `json:"documents"`
+	FilterFile      string                      `json:"filter_file,omitempty"`
+	Collections     map[string]CollectionStatus `json:"collections,omitempty"`
+}
+
+// CollectionStatus describes an indexed collection.
+type CollectionStatus struct {
+	Documents  int      `json:"documents"`
+	Searchable []string `json:"searchable"`
+	Sortable   []string `json:"sortable,omitempty"`
+}
+
+// Status returns the state of the text index.
+func (ti *TextIndex) Status() Status {
+	// While building, the index is locked by long running updates.
+	if !ti.ready.Load() {
+		return Status{
+			State:      "building",
+			Progress:   ti.fillProgress(),
+			FilterFile: ti.cfg.Models.Search,
+		}
+	}
+
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	status := Status{
+		State:      "ready",
+		Progress:   100,
+		FilterFile: ti.cfg.Models.Search,
+	}
+
+	if ti.rebuilding.Load() {
+		status.State = "rebuilding"
+	}
+	status.Generation = ti.db.gen
+	status.IndexGeneration = ti.generation()
+	status.LastUpdate = ti.db.last
+	status.Index = ti.dir
+	status.IndexSize = dirSize(ti.dir)
+	status.Collections = make(map[string]CollectionStatus, len(ti.collections))
+
+	for cname, col := range ti.collections {
+		cs := CollectionStatus{
+			Documents:  len(ti.db.collections[cname]),
+			Searchable: []string{},
+		}
+		for fname, f := range col.Fields {
+			if f.Searchable {
+				cs.Searchable = append(cs.Searchable, fname)
+			}
+			if f.Sortable {
+				cs.Sortable = append(cs.Sortable, fname)
+			}
+		}
+		sort.Strings(cs.Searchable)
+		sort.Strings(cs.Sortable)
+		status.Documents += cs.Documents
+		status.Collections[cname] = cs
+	}
+	return status
+}
+
+// dirSize returns the size in bytes of the files in a directory.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// ForceUpdate updates the index regardless of the age of the last
+// update and repairs it if it went out of sync with the database.
+func (ti *TextIndex) ForceUpdate() error {
+	if !ti.ready.Load() {
+		return ErrIndexBuilding
+	}
+	return ti.apply(func(handler eventHandler) error {
+		if err := ti.db.forceUpdate(handler); err != nil {
+			return err
+		}
+		return ti.db.sweep(handler)
+	})
+}
+
+// IsSuperadmin tells if the user has the organization management
+// level superadmin.
+func (ti *TextIndex) IsSuperadmin(ctx context.Context, userID int) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	level, err := NewDatabase(ti.cfg).managementLevel(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return level == "superadmin", nil
+}
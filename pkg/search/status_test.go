@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+func TestStatusWhileBuildingDoesNotWaitForTheLock(t *testing.T) {
+	db := NewDatabase(&config.Config{})
+	db.fillTotal.Store(200)
+	db.fillDone.Store(50)
+	ti, err := NewTextIndex(&config.Config{}, db, meta.Collections{})
+	if err != nil {
+		t.Fatalf("creating text index: %v", err)
+	}
+
+	// An update holds the lock, e.g. the catch-up of a reopened index.
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	done := make(chan Status)
+	go func() { done <- ti.Status() }()
+
+	select {
+	case status := <-done:
+		if status.State != "building" || status.Progress != 25 {
+			t.Errorf("got state %q with progress %v, want building with 25", status.State, status.Progress)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Status waits for the lock")
+	}
+	if got := ti.fillProgress(); got != 25 {
+		t.Errorf("fillProgress() = %v, want 25", got)
+	}
+}
+
+func TestClosedIndex(t *testing.T) {
+	collections := meta.Collections{
+		"motion": {Fields: map[string]*meta.Member{
+			"title": {Type: "string", Searchable: true, Autocomplete: true},
+		}},
+	}
+	cfg := &config.Config{}
+	cfg.Index.File = t.TempDir() + "/index"
+	ti, err := NewTextIndex(cfg, NewDatabase(cfg), collections)
+	if err != nil {
+		t.Fatalf("creating text index: %v", err)
+	}
+	index, err := bleve.New(cfg.Index.File, ti.indexMapping)
+	if err != nil {
+		t.Fatalf("creating index: %v", err)
+	}
+	ti.use(index, cfg.Index.File)
+	ti.ready.Store(true)
+
+	if err := ti.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+
+	// Updates and queries may still be running during the shutdown.
+	if err := ti.updateFQIDs([]string{"motion/1"}); !errors.Is(err, errIndexClosed) {
+		t.Errorf("updating: got %v, want %v", err, errIndexClosed)
+	}
+	if _, err := ti.Search(context.Background(), &Request{Question: "Haushalt", Size: 10}); !errors.Is(err, errIndexClosed) {
+		t.Errorf("searching: got %v, want %v", err, errIndexClosed)
+	}
+	if _, err := ti.Complete(context.Background(), "Haus", 0); !errors.Is(err, errIndexClosed) {
+		t.Errorf("completing: got %v, want %v", err, errIndexClosed)
+	}
+}
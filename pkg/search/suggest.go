@@ -0,0 +1,378 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+const (
+	// suggestThreshold is the number of hits below which
+	// suggestions are made.
+	suggestThreshold = 3
+	// maxSuggestions is the maximum number of suggestions.
+	maxSuggestions = 3
+	// suggestCandidates is the number of most frequent candidates
+	// per term which are looked up in the documents.
+	suggestCandidates = 10
+	// suggestLookups is the maximum number of candidates of all terms
+	// of a question which are looked up in the documents.
+	suggestLookups = 10
+	// sourcesPerTerm is the number of documents containing a proposed
+	// term which are given to check it with the restricter.
+	sourcesPerTerm = 5
+	// suggestMinLength is the number of characters a term needs
+	// to be corrected.
+	suggestMinLength = 3
+	// suggestLongTerm is the number of characters from which on
+	// a term is corrected with two edits.
+	suggestLongTerm = 5
+)
+
+// dictFields returns the names of the fields with unstemmed terms
+// suitable as spelling dictionary.
+func dictFields(collections meta.Collections) []string {
+	return dictFieldsOf(collections, func(*meta.Member) bool { return true })
+}
+
+// dictFieldsOf returns the names of the dictionaries with unstemmed
+// terms of the fields selected by the given function.
+func dictFieldsOf(collections meta.Collections, selected func(*meta.Member) bool) []string {
+	names := map[string]struct{}{}
+	for _, col := range collections {
+		for fname, f := range col.Fields {
+			if !f.Searchable || !selected(f) {
+				continue
+			}
+			if f.Analyzer != nil {
+				if *f.Analyzer == "simple" {
+					names[fname] = struct{}{}
+				}
+				continue
+			}
+			if f.Type == "string" || f.Type == "text" {
+				names["_"+fname+"_original"] = struct{}{}
+			}
+		}
+	}
+	fields := make([]string, 0, len(names))
+	for fname := range names {
+		fields = append(fields, fname)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// splitQuestion splits a question at white space outside of phrases.
+func splitQuestion(question string) []string {
+	var (
+		words  []string
+		word   strings.Builder
+		phrase bool
+	)
+	for _, r := range question {
+		switch {
+		case r == '"':
+			phrase = !phrase
+			word.WriteRune(r)
+		case !phrase && (r == ' ' || r == '\t' || r == '\n'):
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// matchCase capitalizes the correction if the word is capitalized.
+func matchCase(word, correction string) string {
+	r, _ := utf8.DecodeRuneInString(word)
+	if !unicode.IsUpper(r) {
+		return correction
+	}
+	c, size := utf8.DecodeRuneInString(correction)
+	return string(unicode.ToUpper(c)) + correction[size:]
+}
+
+// candidate is a possible correction of a term.
+type candidate struct {
+	term  string
+	count uint64
+}
+
+// TermSources are documents containing terms proposed to the user keyed
+// by fqid. The matched words of an answer are the proposed terms in each
+// field. As the dictionaries hold the terms of all documents, a term may
+// only be shown to users who can see one of the fields containing it.
+type TermSources map[string]Answer
+
+// add notes that the field of the document contains the term.
+func (s TermSources) add(fqid, field, term string) {
+	answer, ok := s[fqid]
+	if !ok {
+		answer = Answer{MatchedWords: map[string][]string{}}
+	}
+	answer.MatchedWords[field] = append(answer.MatchedWords[field], term)
+	s[fqid] = answer
+}
+
+// correction are the ranked candidates for a word of a question.
+type correction struct {
+	word string
+	// prefix is kept in front of the corrected word.
+	prefix string
+	terms  []string
+}
+
+// Suggestions returns spelling corrections of the question if it has
+// only few hits. Only terms accepted by visible are used, nil accepts
+// all terms.
+func (r *Result) Suggestions(visible func(term string) bool) []string {
+	corrected := make([][]string, len(r.corrections))
+	rounds := 0
+	for i, c := range r.corrections {
+		for _, term := range c.terms {
+			if len(corrected[i]) == maxSuggestions {
+				break
+			}
+			if visible == nil || visible(term) {
+				corrected[i] = append(corrected[i], c.prefix+matchCase(c.word[len(c.prefix):], term))
+			}
+		}
+		rounds = max(rounds, len(corrected[i]))
+	}
+
+	var suggestions []string
+	for k := 0; k < rounds; k++ {
+		words := make([]string, len(r.corrections))
+		for i, c := range r.corrections {
+			words[i] = c.word
+			if n := len(corrected[i]); n > 0 {
+				words[i] = corrected[i][min(k, n-1)]
+			}
+		}
+		suggestions = append(suggestions, strings.Join(words, " "))
+	}
+	return suggestions
+}
+
+// suggestions returns the candidates for corrections of the words of
+// the question built of the terms of the index dictionaries close to
+// them and adds documents containing them to the sources. They are
+// ranked by their frequency in the meeting if a meeting query is given
+// and by their frequency in the whole index otherwise. The caller has
+// to hold the read lock.
+func (ti *TextIndex) suggestions(
+	ctx context.Context,
+	question string,
+	meetingQuery query.Query,
+	sources TermSources,
+) ([]correction, error) {
+	if len(ti.dictFields) == 0 {
+		return nil, nil
+	}
+
+	advanced, err := ti.index.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	fuzzyReader, ok := reader.(index.IndexReaderFuzzy)
+	if !ok {
+		return nil, nil
+	}
+
+	words := splitQuestion(question)
+	corrections := make([]correction, len(words))
+	candidates := make([][]candidate, len(words))
+	for i, w := range words {
+		corrections[i].word = w
+		prefix := ""
+		if w[0] == '+' {
+			prefix, w = "+", w[1:]
+		}
+		term := strings.ToLower(w)
+		if _, ok := operators[w]; ok {
+			continue
+		}
+		if w == "" || w[0] == '-' || w[0] == '"' ||
+			strings.ContainsAny(term, `*?:~^()\`) ||
+			utf8.RuneCountInString(term) < suggestMinLength {
+			continue
+		}
+
+		if candidates[i], err = ti.candidates(fuzzyReader, term); err != nil {
+			return nil, err
+		}
+		corrections[i].prefix = prefix
+	}
+
+	// Every lookup is a search, so all terms share a fixed number.
+	shareLookups(candidates, suggestLookups)
+	found := false
+	for i := range corrections {
+		if len(candidates[i]) == 0 {
+			continue
+		}
+		looked, err := ti.lookupCandidates(ctx, candidates[i], ti.dictFields, meetingQuery, sources)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range looked {
+			corrections[i].terms = append(corrections[i].terms, c.term)
+		}
+		found = found || len(looked) > 0
+	}
+	if !found {
+		return nil, nil
+	}
+	return corrections, nil
+}
+
+// candidates returns the most frequent terms of the dictionaries close
+// to the given term. Returns nothing if the term itself is known.
+func (ti *TextIndex) candidates(reader index.IndexReaderFuzzy, term string) ([]candidate, error) {
+	distance := 1
+	if utf8.RuneCountInString(term) >= suggestLongTerm {
+		distance = maxFuzzyDistance
+	}
+
+	counts := map[string]uint64{}
+	for _, field := range ti.dictFields {
+		dict, err := reader.FieldDictFuzzy(field, term, distance, "")
+		if err != nil {
+			return nil, fmt.Errorf("reading dictionary of %s failed: %w", field, err)
+		}
+		for {
+			entry, err := dict.Next()
+			if err != nil {
+				dict.Close()
+				return nil, fmt.Errorf("reading dictionary of %s failed: %w", field, err)
+			}
+			if entry == nil {
+				break
+			}
+			counts[entry.Term] += entry.Count
+		}
+		dict.Close()
+	}
+	if counts[term] > 0 {
+		return nil, nil
+	}
+
+	candidates := make([]candidate, 0, len(counts))
+	for t, c := range counts {
+		candidates = append(candidates, candidate{term: t, count: c})
+	}
+	sortCandidates(candidates)
+	if len(candidates) > suggestCandidates {
+		candidates = candidates[:suggestCandidates]
+	}
+	return candidates, nil
+}
+
+// shareLookups shortens the lists of candidates to at most n candidates
+// in total. They are shared equally, a list with fewer candidates than
+// its share leaves the rest to the following ones.
+func shareLookups(candidates [][]candidate, n int) {
+	pending := 0
+	for _, c := range candidates {
+		if len(c) > 0 {
+			pending++
+		}
+	}
+	for i, c := range candidates {
+		if len(c) == 0 {
+			continue
+		}
+		share := n / pending
+		if share == 0 && n > 0 {
+			share = 1
+		}
+		candidates[i] = c[:min(share, len(c))]
+		n -= len(candidates[i])
+		pending--
+	}
+}
+
+// lookupCandidates searches documents containing the candidates in one
+// of the given fields and adds some of them to the sources. With a
+// meeting query only documents of the meeting are searched and the
+// counts of the candidates are replaced by the number of documents
+// containing them. Candidates without documents are dropped.
+func (ti *TextIndex) lookupCandidates(
+	ctx context.Context,
+	candidates []candidate,
+	fields []string,
+	meetingQuery query.Query,
+	sources TermSources,
+) ([]candidate, error) {
+	found := candidates[:0]
+	for _, c := range candidates {
+		termQueries := make([]query.Query, len(fields))
+		for i, field := range fields {
+			termQuery := bleve.NewTermQuery(c.term)
+			termQuery.SetField(field)
+			termQueries[i] = termQuery
+		}
+		var q query.Query = bleve.NewDisjunctionQuery(termQueries...)
+		if meetingQuery != nil {
+			q = bleve.NewConjunctionQuery(meetingQuery, q)
+		}
+		request := bleve.NewSearchRequestOptions(q, sourcesPerTerm, 0, false)
+		request.IncludeLocations = true
+		result, err := ti.alias.SearchInContext(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if result.Total == 0 {
+			continue
+		}
+		for _, hit := range result.Hits {
+			for field, terms := range hit.Locations {
+				if _, ok := terms[c.term]; ok {
+					sources.add(hit.ID, field, c.term)
+				}
+			}
+		}
+		if meetingQuery != nil {
+			c.count = result.Total
+		}
+		found = append(found, c)
+	}
+	sortCandidates(found)
+	return found, nil
+}
+
+// sortCandidates orders the candidates by descending count.
+func sortCandidates(candidates []candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].count != candidates[j].count {
+			return candidates[i].count > candidates[j].count
+		}
+		return candidates[i].term < candidates[j].term
+	})
+}
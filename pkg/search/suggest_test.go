@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// newProposalIndex returns a text index with motions whose titles
+// are proposed as completions and corrections.
+func newProposalIndex(t *testing.T) *TextIndex {
+	t.Helper()
+	collections := meta.Collections{
+		"motion": {Fields: map[string]*meta.Member{
+			"title": {Type: "string", Searchable: true, Autocomplete: true},
+			"text":  {Type: "HTMLStrict", Searchable: true},
+		}},
+	}
+	cfg := &config.Config{}
+	ti, err := NewTextIndex(cfg, nil, collections)
+	if err != nil {
+		t.Fatalf("creating text index: %v", err)
+	}
+	index, err := bleve.New(t.TempDir()+"/index", ti.indexMapping)
+	if err != nil {
+		t.Fatalf("creating index: %v", err)
+	}
+	t.Cleanup(func() { index.Close() })
+	ti.use(index, "")
+	ti.ready.Store(true)
+
+	for fqid, title := range map[string]string{
+		"motion/1": "Haushalt",
+		"motion/2": "Haushalt Nachtrag",
+		"motion/3": "Hausordnung",
+	} {
+		doc := newBleveType("motion")
+		doc["title"] = title
+		doc["_title_original"] = title
+		if err := index.Index(fqid, doc); err != nil {
+			t.Fatalf("indexing: %v", err)
+		}
+	}
+	return ti
+}
+
+func TestComplete(t *testing.T) {
+	ti := newProposalIndex(t)
+
+	completions, err := ti.Complete(context.Background(), "neuer Haus", 0)
+	if err != nil {
+		t.Fatalf("completing: %v", err)
+	}
+
+	if got, want := completions.List(nil, 10), []string{"neuer Haushalt", "neuer Hausordnung"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(nil) = %v, want %v", got, want)
+	}
+	if got, want := completions.List(nil, 1), []string{"neuer Haushalt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(nil, 1) = %v, want %v", got, want)
+	}
+	visible := func(term string) bool { return term == "hausordnung" }
+	if got, want := completions.List(visible, 10), []string{"neuer Hausordnung"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List(visible) = %v, want %v", got, want)
+	}
+
+	wantSources := TermSources{
+		"motion/1": {MatchedWords: map[string][]string{"_title_original": {"haushalt"}}},
+		"motion/2": {MatchedWords: map[string][]string{"_title_original": {"haushalt"}}},
+		"motion/3": {MatchedWords: map[string][]string{"_title_original": {"hausordnung"}}},
+	}
+	if !reflect.DeepEqual(completions.Sources, wantSources) {
+		t.Errorf("sources = %v, want %v", completions.Sources, wantSources)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	ti := newProposalIndex(t)
+
+	result, err := ti.Search(context.Background(), &Request{Question: "+Haushlat 2024", Size: 10})
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+
+	if got, want := result.Suggestions(nil), []string{"+Haushalt 2024"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Suggestions(nil) = %v, want %v", got, want)
+	}
+	hidden := func(string) bool { return false }
+	if got := result.Suggestions(hidden); got != nil {
+		t.Errorf("Suggestions(hidden) = %v, want none", got)
+	}
+
+	wantSources := TermSources{
+		"motion/1": {MatchedWords: map[string][]string{"_title_original": {"haushalt"}}},
+		"motion/2": {MatchedWords: map[string][]string{"_title_original": {"haushalt"}}},
+	}
+	if !reflect.DeepEqual(result.SuggestionSources, wantSources) {
+		t.Errorf("sources = %v, want %v", result.SuggestionSources, wantSources)
+	}
+}
+
+func TestShareLookups(t *testing.T) {
+	list := func(n int) []candidate {
+		return make([]candidate, n)
+	}
+	for _, tt := range []struct {
+		name  string
+		sizes []int
+		n     int
+		want  []int
+	}{
+		{"equal", []int{10, 10, 10}, 10, []int{3, 3, 4}},
+		{"rest to the following", []int{1, 0, 10}, 10, []int{1, 0, 9}},
+		{"enough", []int{2, 3}, 10, []int{2, 3}},
+		{"more terms than lookups", []int{5, 5, 5}, 2, []int{1, 1, 0}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := make([][]candidate, len(tt.sizes))
+			for i, size := range tt.sizes {
+				candidates[i] = list(size)
+			}
+			shareLookups(candidates, tt.n)
+			got := make([]int, len(candidates))
+			for i, c := range candidates {
+				got[i] = len(c)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,13 +5,17 @@
 package search
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 
@@ -25,56 +29,141 @@ import (
 	bleveHtml "github.com/blevesearch/bleve/v2/analysis/char/html"
 	"github.com/blevesearch/bleve/v2/analysis/lang/de"
 	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/single"
 	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
 	"github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/registry"
 	"github.com/blevesearch/bleve/v2/search/query"
 	"github.com/buger/jsonparser"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // TextIndex manages a text index over a given database.
+// Searches may run concurrently while updates are exclusive.
 type TextIndex struct {
-	cfg          *config.Config
-	db           *Database
+	mu  sync.RWMutex
+	gen atomic.Uint64
+	cfg *config.Config
+	db  *Database
+	// fillDB is db readable without the lock to report the
+	// progress of filling it while updates hold the lock.
+	fillDB       atomic.Pointer[Database]
 	collections  meta.Collections
 	indexMapping mapping.IndexMapping
-	index        bleve.Index
+	// index is written by updates and searched through alias,
+	// behind which a rebuild swaps it.
+	index bleve.Index
+	alias bleve.IndexAlias
+	// dir is the directory of the index.
+	dir string
+	// textFields are loaded with the hits to build snippets.
+	textFields []string
+	sortFields map[string]struct{}
+	// dictFields have dictionaries of unstemmed terms.
+	dictFields []string
+	// completeFields are the dictionaries for autocompletion.
+	completeFields []string
+	// queryFields are the fields which can be named in questions.
+	queryFields map[string][]queryField
+	// ready is set when the index is opened.
+	ready      atomic.Bool
+	rebuilding atomic.Bool
+	// lastUpdate is the time in unix nanoseconds of the
+	// last successful update from the database.
+	lastUpdate      atomic.Int64
+	updateDuration  prometheus.Histogram
+	updateBatchSize prometheus.Histogram
 }
 
-// NewTextIndex creates a new text index.
+// NewTextIndex creates a new text index. It has to be opened
+// before it can be searched.
 func NewTextIndex(
 	cfg *config.Config,
 	db *Database,
 	collections meta.Collections,
 ) (*TextIndex, error) {
 	ti := &TextIndex{
-		cfg:          cfg,
-		db:           db,
-		collections:  collections,
-		indexMapping: buildIndexMapping(collections),
+		cfg:             cfg,
+		db:              db,
+		indexMapping:    buildIndexMapping(collections),
+		updateDuration:  newUpdateDuration(),
+		updateBatchSize: newUpdateBatchSize(),
 	}
+	ti.fillDB.Store(db)
+	ti.setCollections(collections)
+	return ti, nil
+}
 
-	if err := ti.build(); err != nil {
-		return nil, err
+// setCollections sets the indexed collections and the fields derived
+// from them.
+func (ti *TextIndex) setCollections(collections meta.Collections) {
+	ti.collections = collections
+	ti.textFields = textFields(collections)
+	ti.sortFields = sortFields(collections)
+	ti.dictFields = dictFields(collections)
+	ti.completeFields = completeFields(collections)
+	ti.queryFields = queryFields(collections)
+}
+
+// Open reopens the index persisted by a previous run or builds
+// a new one. This may take a while on large databases.
+func (ti *TextIndex) Open() error {
+	if err := ti.reopen(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warnf("reusing persisted text index failed: %v\n", err)
+		}
+		if err := ti.build(); err != nil {
+			return err
+		}
 	}
+	ti.lastUpdate.Store(time.Now().UnixNano())
+	ti.ready.Store(true)
+	return nil
+}
 
-	return ti, nil
+// ErrIndexBuilding is reported while the text index is opened.
+var ErrIndexBuilding = errors.New("text index is being built")
+
+// errIndexClosed is reported by operations on a closed text index.
+var errIndexClosed = errors.New("text index was closed")
+
+// readyUpdateFactor is the number of update intervals after which
+// an index without successful updates is not ready anymore.
+const readyUpdateFactor = 3
+
+// Ready returns nil if the index is opened and was recently
+// updated from the database.
+func (ti *TextIndex) Ready() error {
+	if !ti.ready.Load() {
+		return ErrIndexBuilding
+	}
+	last := time.Unix(0, ti.lastUpdate.Load())
+	if age := time.Since(last); age > readyUpdateFactor*ti.cfg.Index.Update {
+		return fmt.Errorf("last successful index update was %v ago",
+			age.Round(time.Second))
+	}
+	return nil
 }
 
-// Close tears down an open text index.
+// Close tears down an open text index. The index is kept on disk
+// to be reopened by the next run.
 func (ti *TextIndex) Close() error {
-	if ti == nil {
+	// An index closed while being built is not worth persisting.
+	if ti == nil || !ti.ready.Load() {
 		return nil
 	}
-	var err1 error
-	if index := ti.index; index != nil {
-		ti.index = nil
-		err1 = index.Close()
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	index := ti.index
+	if index == nil {
+		return nil
 	}
-	if err2 := os.RemoveAll(ti.cfg.Index.File); err1 == nil {
-		err1 = err2
+	ti.index = nil
+	ti.alias.Close()
+	if err := index.Close(); err != nil {
+		return err
 	}
-	return err1
+	return ti.persist()
 }
 
 const deHTML = "de_html"
@@ -131,8 +220,33 @@ func (f *specialCharFilter) Filter(input []byte) []byte {
 	return input
 }
 
+const sortKey = "sort_key"
+
+func sortKeyAnalyzerConstructor(
+	config map[string]interface{},
+	cache *registry.Cache,
+) (analysis.Analyzer, error) {
+
+	singleTokenizer, err := cache.TokenizerNamed(single.Name)
+	if err != nil {
+		return nil, err
+	}
+	toLowerFilter, err := cache.TokenFilterNamed(lowercase.Name)
+	if err != nil {
+		return nil, err
+	}
+	rv := analysis.DefaultAnalyzer{
+		Tokenizer: singleTokenizer,
+		TokenFilters: []analysis.TokenFilter{
+			toLowerFilter,
+		},
+	}
+	return &rv, nil
+}
+
 func init() {
 	registry.RegisterAnalyzer(deHTML, deHTMLAnalyzerConstructor)
+	registry.RegisterAnalyzer(sortKey, sortKeyAnalyzerConstructor)
 }
 
 type bleveType map[string]any
@@ -164,12 +278,23 @@ func buildIndexMapping(collections meta.Collections) mapping.IndexMapping {
 	simpleFieldMapping := bleve.NewTextFieldMapping()
 	simpleFieldMapping.Analyzer = simple.Name
 
+	sortTextFieldMapping := bleve.NewTextFieldMapping()
+	sortTextFieldMapping.Analyzer = sortKey
+	sortTextFieldMapping.IncludeInAll = false
+	sortTextFieldMapping.IncludeTermVectors = false
+	sortTextFieldMapping.Store = false
+
+	sortNumberFieldMapping := bleve.NewNumericFieldMapping()
+	sortNumberFieldMapping.IncludeInAll = false
+	sortNumberFieldMapping.Store = false
+
 	indexMapping := mapping.NewIndexMapping()
 	indexMapping.TypeField = "_bleve_type"
 
 	for name, col := range collections {
 		docMapping := bleve.NewDocumentMapping()
 		docMapping.AddFieldMappingsAt("_bleve_type", collectionInfoFieldMapping)
+		docMapping.AddFieldMappingsAt("_meeting_id", collectionInfoFieldMapping)
 		for fname, cf := range col.Fields {
 			if cf.Searchable {
 				if cf.Analyzer == nil {
@@ -199,6 +324,13 @@ func buildIndexMapping(collections meta.Collections) mapping.IndexMapping {
 					}
 				}
 			}
+			if cf.Sortable {
+				if isNumericSortField(cf) {
+					docMapping.AddFieldMappingsAt(sortFieldPrefix+fname, sortNumberFieldMapping)
+				} else {
+					docMapping.AddFieldMappingsAt(sortFieldPrefix+fname, sortTextFieldMapping)
+				}
+			}
 		}
 		indexMapping.AddDocumentMapping(name, docMapping)
 	}
@@ -209,6 +341,17 @@ func buildIndexMapping(collections meta.Collections) mapping.IndexMapping {
 }
 
 func (bt bleveType) fill(fields map[string]*meta.Member, data []byte) {
+	// Keep the meeting as a keyword to be able to facet over it.
+	if v, err := jsonparser.GetInt(data, "meeting_id"); err == nil {
+		bt["_meeting_id"] = strconv.FormatInt(v, 10)
+	}
+
+	for fname, field := range fields {
+		if field.Sortable {
+			bt.fillSortField(fname, field, data)
+		}
+	}
+
 	for fname, field := range fields {
 		if !field.Searchable {
 			continue
@@ -257,16 +400,162 @@ func (bt bleveType) fill(fields map[string]*meta.Member, data []byte) {
 	}
 }
 
+const sortFieldPrefix = "_sort_"
+
+func isNumericSortField(field *meta.Member) bool {
+	switch field.Type {
+	case "number", "timestamp", "relation":
+		return true
+	}
+	return false
+}
+
+func (bt bleveType) fillSortField(fname string, field *meta.Member, data []byte) {
+	if isNumericSortField(field) {
+		if v, err := jsonparser.GetInt(data, fname); err == nil {
+			bt[sortFieldPrefix+fname] = v
+		}
+		return
+	}
+	if v, err := jsonparser.GetString(data, fname); err == nil {
+		bt[sortFieldPrefix+fname] = v
+	}
+}
+
+func sortFields(collections meta.Collections) map[string]struct{} {
+	fields := map[string]struct{}{}
+	for _, col := range collections {
+		for fname, f := range col.Fields {
+			if f.Sortable {
+				fields[fname] = struct{}{}
+			}
+		}
+	}
+	return fields
+}
+
+// sortOrder translates the requested sort keys into a bleve sort order.
+func (ti *TextIndex) sortOrder(keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	order := make([]string, 0, len(keys)+2)
+	hasScore := false
+	for _, key := range keys {
+		name, desc := strings.CutPrefix(key, "-")
+		if name == "score" {
+			hasScore = true
+			if desc {
+				order = append(order, "_score")
+			} else {
+				order = append(order, "-_score")
+			}
+			continue
+		}
+		if _, ok := ti.sortFields[name]; !ok {
+			return nil, requestErrorf("unknown sort field %q", name)
+		}
+		field := sortFieldPrefix + name
+		if desc {
+			field = "-" + field
+		}
+		order = append(order, field)
+	}
+	if !hasScore {
+		order = append(order, "-_score")
+	}
+	// Keep the order stable for paging.
+	order = append(order, "_id")
+	return order, nil
+}
+
 func (ti *TextIndex) update() error {
+	return ti.apply(func(handler eventHandler) error {
+		return ti.db.update(handler)
+	})
+}
+
+// reconcile updates the index and repairs it if the bookkeeping
+// of the database went out of sync.
+func (ti *TextIndex) reconcile() error {
+	return ti.apply(func(handler eventHandler) error {
+		return ti.db.reconcile(handler)
+	})
+}
+
+// updateFQIDs applies the changes of the given fqids to the index.
+func (ti *TextIndex) updateFQIDs(fqids []string) error {
+	return ti.apply(func(handler eventHandler) error {
+		return ti.db.fetch(fqids, handler)
+	})
+}
 
-	batch, batchCount := ti.index.NewBatch(), 0
+// fillProgress returns the progress in percent of reading the
+// database to build the index.
+func (ti *TextIndex) fillProgress() float64 {
+	db := ti.fillDB.Load()
+	if db == nil {
+		return 0
+	}
+	return db.fillProgress()
+}
 
-	if err := ti.db.update(func(
+// generation returns a number which changes with every change
+// of the index.
+func (ti *TextIndex) generation() uint64 {
+	return ti.gen.Load()
+}
+
+// outdated tells if the index should be updated before searching.
+func (ti *TextIndex) outdated() bool {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	return ti.db.outdated(time.Now())
+}
+
+// apply writes the events produced by the given database operation
+// to the index. The operation runs under the write lock, so it may
+// use ti.db which is replaced by rebuilds.
+func (ti *TextIndex) apply(produce func(eventHandler) error) error {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	// Updates may race the shutdown.
+	if ti.index == nil {
+		return errIndexClosed
+	}
+
+	start := time.Now()
+	total, err := writeEvents(ti.index, ti.collections, ti.cfg.Index.Batch, produce)
+	ti.updateDuration.Observe(time.Since(start).Seconds())
+	if total > 0 {
+		ti.gen.Add(1)
+		ti.updateBatchSize.Observe(float64(total))
+	}
+	if err != nil {
+		return err
+	}
+
+	ti.lastUpdate.Store(time.Now().UnixNano())
+	return nil
+}
+
+// writeEvents writes the events produced by the given database operation
+// to an index in batches. Returns the number of written documents.
+func writeEvents(
+	index bleve.Index,
+	collections meta.Collections,
+	batchSize int,
+	produce func(eventHandler) error,
+) (int, error) {
+	batch, batchCount, total := index.NewBatch(), 0, 0
+
+	if err := produce(func(
 		evt updateEventType,
 		col string, id int, data []byte,
 	) error {
 		// we dont care if its not an indexed type.
-		mcol := ti.collections[col]
+		mcol := collections[col]
 		if mcol == nil {
 			return nil
 		}
@@ -286,24 +575,25 @@ func (ti *TextIndex) update() error {
 		case removeEvent:
 			batch.Delete(fqid)
 		}
-		if batchCount++; batchCount >= ti.cfg.Index.Batch {
-			if err := ti.index.Batch(batch); err != nil {
-				return err
+		total++
+		if batchCount++; batchCount >= batchSize {
+			if err := index.Batch(batch); err != nil {
+				return fmt.Errorf("writing batch failed: %w", err)
 			}
-			batch, batchCount = ti.index.NewBatch(), 0
+			batch, batchCount = index.NewBatch(), 0
 		}
 		return nil
 	}); err != nil {
-		return err
+		return total, err
 	}
 
 	if batchCount > 0 {
-		if err := ti.index.Batch(batch); err != nil {
-			return err
+		if err := index.Batch(batch); err != nil {
+			return total, fmt.Errorf("writing batch failed: %w", err)
 		}
 	}
 
-	return nil
+	return total, nil
 }
 
 func (ti *TextIndex) build() error {
@@ -324,48 +614,72 @@ func (ti *TextIndex) build() error {
 				"removing index file %q failed: %w", ti.cfg.Index.File, err)
 		}
 	}
+	ti.removeStaleIndexes("")
 
-	index, err := bleve.New(ti.cfg.Index.File, ti.indexMapping)
-	if err != nil {
+	// Remove a stale state of a persisted index.
+	if err := os.Remove(ti.stateFile()); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf(
-			"opening index file %q failed: %w", ti.cfg.Index.File, err)
+			"removing state file %q failed: %w", ti.stateFile(), err)
 	}
 
-	batch, batchCount := index.NewBatch(), 0
+	index, err := buildIndex(
+		ti.cfg.Index.File, ti.cfg.Index.Batch,
+		ti.db, ti.collections, ti.indexMapping)
+	if err != nil {
+		return err
+	}
 
-	if err := ti.db.fill(func(_ updateEventType, col string, id int, data []byte) error {
-		// Dont care for collections which are not text indexed.
-		mcol := ti.collections[col]
-		if mcol == nil {
-			return nil
-		}
-		bt := newBleveType(col)
-		bt.fill(mcol.Fields, data)
+	ti.use(index, ti.cfg.Index.File)
 
-		fqid := col + "/" + strconv.Itoa(id)
-		batch.Index(fqid, bt)
-		if batchCount++; batchCount >= ti.cfg.Index.Batch {
-			if err := index.Batch(batch); err != nil {
-				return fmt.Errorf("writing batch failed: %w", err)
-			}
-			batch, batchCount = index.NewBatch(), 0
-		}
-		return nil
-	}); err != nil {
+	return nil
+}
+
+// buildIndex creates a new index in the given directory and fills it
+// with all documents of the database.
+func buildIndex(
+	dir string,
+	batchSize int,
+	db *Database,
+	collections meta.Collections,
+	indexMapping mapping.IndexMapping,
+) (bleve.Index, error) {
+	index, err := bleve.New(dir, indexMapping)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"opening index file %q failed: %w", dir, err)
+	}
+
+	if _, err := writeEvents(index, collections, batchSize, db.fill); err != nil {
 		index.Close()
-		return err
+		return nil, err
 	}
 
-	if batchCount > 0 {
-		if err := index.Batch(batch); err != nil {
-			index.Close()
-			return fmt.Errorf("writing batch failed: %w", err)
-		}
+	return index, nil
+}
+
+// use makes the given index the one to be searched and updated.
+func (ti *TextIndex) use(index bleve.Index, dir string) {
+	ti.index, ti.dir = index, dir
+	ti.alias = bleve.NewIndexAlias(index)
+}
+
+// newMeetingQuery matches the documents belonging to a meeting.
+// Returns nil if no meeting is given.
+func newMeetingQuery(meetingID int) query.Query {
+	if meetingID <= 0 {
+		return nil
 	}
+	fmid := float64(meetingID)
+	meetingIDQuery := newNumericQuery(fmid)
+	meetingIDQuery.SetField("meeting_id")
 
-	ti.index = index
+	meetingIDsQuery := newNumericQuery(fmid)
+	meetingIDsQuery.SetField("meeting_ids")
 
-	return nil
+	meetingIDOwnerQuery := bleve.NewTermQuery("meeting/" + strconv.Itoa(meetingID))
+	meetingIDOwnerQuery.SetField("owner_id")
+
+	return bleve.NewDisjunctionQuery(meetingIDQuery, meetingIDsQuery, meetingIDOwnerQuery)
 }
 
 func newNumericQuery(num float64) *query.NumericRangeQuery {
@@ -378,79 +692,149 @@ func newNumericQuery(num float64) *query.NumericRangeQuery {
 
 // Answer contains additional information of an search results answer
 type Answer struct {
-	Score        float64
-	MatchedWords map[string][]string
-}
-
-func filterExactMatchTerms(question string) string {
-	exactmatchFiltered := bytes.Buffer{}
-	throwAway := false
-	for _, w := range question {
-		if w == '"' {
-			throwAway = !throwAway
-		} else if !throwAway {
-			exactmatchFiltered.WriteRune(w)
-		}
+	Score        float64             `json:"score"`
+	MatchedWords map[string][]string `json:"matched_by,omitempty"`
+	// Snippets are HTML fragments of the matched fields
+	// with the matches enclosed in <mark> tags.
+	Snippets map[string][]string `json:"snippets,omitempty"`
+}
+
+// Facets are the numbers of hits per collection and meeting.
+type Facets struct {
+	Collections map[string]int `json:"collections"`
+	Meetings    map[int]int    `json:"meetings"`
+}
+
+const (
+	collectionsFacet = "collections"
+	meetingsFacet    = "meetings"
+	// meetingFacetSize is the maximum number of meetings reported in facets.
+	meetingFacetSize = 100
+)
+
+// Request describes a query against the text index.
+type Request struct {
+	Question    string
+	Collections []string
+	MeetingID   int
+	From        int
+	Size        int
+	// Facets requests the numbers of hits per collection and meeting.
+	Facets bool
+	// Sort lists the fields to order the hits by. A leading '-' sorts
+	// descending. "score" orders by relevance with the best hits first.
+	Sort []string
+}
+
+// Result is a page of answers of a search.
+type Result struct {
+	// Total is the number of hits in the index regardless of paging.
+	Total   uint64
+	Answers map[string]Answer
+	// FQIDs are the keys of Answers in rank order.
+	FQIDs []string
+	// Facets are only filled if requested. The collection filter
+	// of the request is not applied to them.
+	Facets *Facets
+	// SuggestionSources are documents containing the terms used by
+	// Suggestions.
+	SuggestionSources TermSources
+	corrections       []correction
+}
+
+const (
+	// maxFuzzyDistance is the maximum edit distance supported by bleve.
+	maxFuzzyDistance = 2
+	// fuzzyBoost scores fuzzy hits below exact and prefix hits.
+	fuzzyBoost = 0.5
+)
+
+// fuzzyQuery matches the term with typos. Returns nil if the term
+// is too short.
+func (ti *TextIndex) fuzzyQuery(term string) query.Query {
+	distance := min(ti.cfg.Fuzzy.Distance, maxFuzzyDistance)
+	if distance <= 0 || utf8.RuneCountInString(term) < ti.cfg.Fuzzy.MinLength {
+		return nil
 	}
+	fuzzyQuery := bleve.NewFuzzyQuery(strings.ToLower(term))
+	fuzzyQuery.SetFuzziness(distance)
+	fuzzyQuery.SetBoost(fuzzyBoost)
+	return fuzzyQuery
+}
 
-	return exactmatchFiltered.String()
+func (ti *TextIndex) addFacets(request *bleve.SearchRequest) {
+	request.AddFacet(collectionsFacet,
+		bleve.NewFacetRequest("_bleve_type", len(ti.collections)))
+	request.AddFacet(meetingsFacet,
+		bleve.NewFacetRequest("_meeting_id", meetingFacetSize))
 }
 
-// Terminates unclosed quotes
-func cleanupQuestion(question string) string {
-	hasUnclosedQuote := false
-	for _, w := range question {
-		if w == '"' {
-			hasUnclosedQuote = !hasUnclosedQuote
+func newFacets(result *bleve.SearchResult) *Facets {
+	facets := &Facets{
+		Collections: map[string]int{},
+		Meetings:    map[int]int{},
+	}
+	if fr := result.Facets[collectionsFacet]; fr != nil && fr.Terms != nil {
+		for _, t := range fr.Terms.Terms() {
+			facets.Collections[t.Term] = t.Count
 		}
 	}
-
-	if hasUnclosedQuote {
-		return question + "\""
+	if fr := result.Facets[meetingsFacet]; fr != nil && fr.Terms != nil {
+		for _, t := range fr.Terms.Terms() {
+			if id, err := strconv.Atoi(t.Term); err == nil {
+				facets.Meetings[id] = t.Count
+			}
+		}
 	}
-
-	return question
+	return facets
 }
 
 // Search queries the internal index for hits.
-func (ti *TextIndex) Search(question string, collections []string, meetingID int) (map[string]Answer, error) {
+func (ti *TextIndex) Search(ctx context.Context, req *Request) (*Result, error) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	if ti.index == nil {
+		return nil, errIndexClosed
+	}
+
+	question, collections, meetingID := req.Question, req.Collections, req.MeetingID
+
+	sortOrder, err := ti.sortOrder(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+
 	start := time.Now()
 	defer func() {
 		log.Debugf("searching for %q took %v\n", question, time.Since(start))
 	}()
 
-	question = cleanupQuestion(question)
-	wildcardQuestion := bytes.Buffer{}
-	for _, w := range strings.Split(filterExactMatchTerms(question), " ") {
-		if len(w) > 2 && w[0] != byte('*') && w[len(w)-1] != byte('*') {
-			wildcardQuestion.WriteString("*" + strings.ToLower(w) + "* ")
-		}
+	matchQuery, err := ti.questionQuery(question)
+	if err != nil {
+		return nil, err
 	}
-	wildcardQuery := bleve.NewQueryStringQuery(wildcardQuestion.String())
 
 	var q query.Query
-	matchQueryOriginal := bleve.NewQueryStringQuery(question)
-	matchQueryOriginal.SetBoost(5)
-	matchQuery := bleve.NewDisjunctionQuery(matchQueryOriginal, wildcardQuery)
-
-	if meetingID > 0 {
-		fmid := float64(meetingID)
-		meetingIDQuery := newNumericQuery(fmid)
-		meetingIDQuery.SetField("meeting_id")
-
-		meetingIDsQuery := newNumericQuery(fmid)
-		meetingIDsQuery.SetField("meeting_ids")
-
-		meetingIDOwnerQuery := bleve.NewTermQuery("meeting/" + strconv.Itoa(meetingID))
-		meetingIDOwnerQuery.SetField("owner_id")
-
-		meetingQuery := bleve.NewDisjunctionQuery(meetingIDQuery, meetingIDsQuery, meetingIDOwnerQuery)
+	meetingQuery := newMeetingQuery(meetingID)
+	if meetingQuery != nil {
 		q = bleve.NewConjunctionQuery(meetingQuery, matchQuery)
 	} else {
 		q = matchQuery
 	}
 
+	var facets *Facets
 	if len(collections) > 0 {
+		// Facets are counted without the collection filter.
+		if req.Facets {
+			facetRequest := bleve.NewSearchRequestOptions(q, 0, 0, false)
+			ti.addFacets(facetRequest)
+			facetResult, err := ti.alias.SearchInContext(ctx, facetRequest)
+			if err != nil {
+				return nil, err
+			}
+			facets = newFacets(facetResult)
+		}
+
 		collQueries := make([]query.Query, len(collections))
 		for i, c := range collections {
 			collQuery := bleve.NewTermQuery(c)
@@ -464,14 +848,26 @@ func (ti *TextIndex) Search(question string, collections []string, meetingID int
 
 	request := bleve.NewSearchRequest(q)
 	request.IncludeLocations = true
-	request.Size = 100
-	result, err := ti.index.Search(request)
+	request.Fields = ti.textFields
+	request.From = req.From
+	request.Size = req.Size
+	if sortOrder != nil {
+		request.SortBy(sortOrder)
+	}
+	if req.Facets && facets == nil {
+		ti.addFacets(request)
+	}
+	result, err := ti.alias.SearchInContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
+	if req.Facets && facets == nil {
+		facets = newFacets(result)
+	}
 	log.Debugf("number hits: %d\n", len(result.Hits))
 	dupes := map[string]struct{}{}
 	answers := make(map[string]Answer, len(result.Hits))
+	fqids := make([]string, 0, len(result.Hits))
 	numDupes := 0
 
 	for i := range result.Hits {
@@ -490,11 +886,29 @@ func (ti *TextIndex) Search(question string, collections []string, meetingID int
 		}
 
 		dupes[fqid] = struct{}{}
+		fqids = append(fqids, fqid)
 		answers[fqid] = Answer{
 			Score:        result.Hits[i].Score,
 			MatchedWords: matchedWords,
+			Snippets:     ti.snippets(result.Hits[i]),
 		}
 	}
 	log.Debugf("number of duplicates: %d\n", numDupes)
-	return answers, nil
+
+	var corrections []correction
+	sources := TermSources{}
+	if result.Total < suggestThreshold && req.From == 0 {
+		if corrections, err = ti.suggestions(ctx, question, meetingQuery, sources); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{
+		Total:             result.Total,
+		Answers:           answers,
+		FQIDs:             fqids,
+		Facets:            facets,
+		SuggestionSources: sources,
+		corrections:       corrections,
+	}, nil
 }
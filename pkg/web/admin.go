@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/search"
+)
+
+// statusError is an error returned to the client with a given status code.
+type statusError struct {
+	err    error
+	typ    string
+	status int
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e statusError) Type() string {
+	return e.typ
+}
+
+func (e statusError) StatusCode() int {
+	return e.status
+}
+
+func (e statusError) Unwrap() error {
+	return e.err
+}
+
+// requirePost answers requests with other methods than POST with an error.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	handleErrorWithStatus(w, statusError{
+		err:    errors.New("only POST is allowed"),
+		typ:    "method_not_allowed",
+		status: http.StatusMethodNotAllowed,
+	})
+	return false
+}
+
+// indexError maps errors of the text index maintenance to client errors.
+func indexError(err error) error {
+	switch {
+	case errors.Is(err, search.ErrRebuildRunning):
+		return statusError{err: err, typ: "rebuild_running", status: http.StatusConflict}
+	case errors.Is(err, search.ErrIndexBuilding):
+		return statusError{err: err, typ: "index_not_ready", status: http.StatusServiceUnavailable}
+	}
+	return err
+}
+
+// rebuild starts a rebuild of the text index in the background.
+func (c *controller) rebuild(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	if err := c.qs.Rebuild(); err != nil {
+		handleErrorWithStatus(w, indexError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, c.qs.Status())
+}
+
+// adminMiddleware only lets superadmins pass.
+func (c *controller) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := c.auth.FromContext(r.Context())
+		ok, err := c.qs.IsSuperadmin(r.Context(), userID)
+		if err != nil {
+			handleErrorWithStatus(w, fmt.Errorf("checking permissions failed: %w", err))
+			return
+		}
+		if !ok {
+			handleErrorWithStatus(w, statusError{
+				err:    errors.New("only superadmins are allowed to administer the search index"),
+				typ:    "forbidden",
+				status: http.StatusForbidden,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// status reports the state of the text index.
+func (c *controller) status(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, c.qs.Status())
+}
+
+// update forces an update of the text index and reports its state.
+func (c *controller) update(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	if err := c.qs.ForceUpdate(); err != nil {
+		handleErrorWithStatus(w, indexError(err))
+		return
+	}
+	writeJSON(w, c.qs.Status())
+}
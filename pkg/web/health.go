@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/search"
+)
+
+// restricterDialTimeout limits the time to check if the
+// restricter is reachable.
+const restricterDialTimeout = 2 * time.Second
+
+// healthResponse is the answer of the health and readiness checks.
+type healthResponse struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Progress of building the text index in percent.
+	Progress *float64 `json:"progress,omitempty"`
+}
+
+// health reports that the process is alive.
+func (c *controller) health(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, healthResponse{Status: "ok"})
+}
+
+// ready reports if the service is able to answer search requests.
+func (c *controller) ready(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ready"}
+
+	if err := c.qs.Ready(); err != nil {
+		resp.Status = "not_ready"
+		if errors.Is(err, search.ErrIndexBuilding) {
+			resp.Status = "building"
+			progress := c.qs.BuildProgress()
+			resp.Progress = &progress
+		}
+		resp.Reasons = append(resp.Reasons, err.Error())
+	}
+
+	if err := c.restricterReachable(r.Context()); err != nil {
+		if resp.Status == "ready" {
+			resp.Status = "not_ready"
+		}
+		resp.Reasons = append(resp.Reasons, err.Error())
+	}
+
+	if resp.Status != "ready" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, resp)
+}
+
+// restricterReachable checks if a connection to the restricter
+// can be established. Without a configured restricter there is
+// nothing to check.
+func (c *controller) restricterReachable(ctx context.Context) error {
+	if c.cfg.Restricter.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.cfg.Restricter.URL)
+	if err != nil {
+		return fmt.Errorf("invalid restricter url: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, restricterDialTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("restricter not reachable: %w", err)
+	}
+	return conn.Close()
+}
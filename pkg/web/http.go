@@ -13,34 +13,103 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/peb-adr/openslides-go/auth"
 	"github.com/OpenSlides/openslides-search-service/pkg/config"
 	"github.com/OpenSlides/openslides-search-service/pkg/meta"
 	"github.com/OpenSlides/openslides-search-service/pkg/oserror"
 	"github.com/OpenSlides/openslides-search-service/pkg/search"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	defaultCompletions = 10
+	maxCompletions     = 50
+
+	// maxRestrictRounds limits how often further index hits are fetched
+	// to fill a page with results visible to the user.
+	maxRestrictRounds = 10
 )
 
 type controller struct {
-	cfg       *config.Config
-	auth      *auth.Auth
-	qs        *search.QueryServer
+	cfg    *config.Config
+	auth   *auth.Auth
+	qs     *search.QueryServer
+	models *Models
+
+	restricterDuration prometheus.Histogram
+	restricterErrors   prometheus.Counter
+}
+
+// Models are the fields requested from the restricter per collection
+// and the collections related to each other. They may be replaced
+// while the web server is running.
+type Models struct {
+	current atomic.Pointer[models]
+}
+
+type models struct {
 	reqFields map[string]map[string]*meta.CollectionRelation
 	collRel   map[string]map[string]struct{}
 }
 
+// NewModels creates new models.
+func NewModels(
+	reqFields map[string]map[string]*meta.CollectionRelation,
+	collRel map[string]map[string]struct{},
+) *Models {
+	m := new(Models)
+	m.Set(reqFields, collRel)
+	return m
+}
+
+// Set replaces the models.
+func (m *Models) Set(
+	reqFields map[string]map[string]*meta.CollectionRelation,
+	collRel map[string]map[string]struct{},
+) {
+	m.current.Store(&models{reqFields: reqFields, collRel: collRel})
+}
+
 type auRequest struct {
 	Ids        []int                               `json:"ids"`
 	Collection string                              `json:"collection"`
 	Fields     map[string]*meta.CollectionRelation `json:"fields"`
 }
 
+// searchResponse is the envelope around a page of search results.
+type searchResponse struct {
+	// Total is the number of hits. With a restricter it is the number of
+	// visible hits found so far, as counting the index hits would count
+	// hits hidden from the user. TotalExact tells if it is the final number.
+	Total      uint64 `json:"total"`
+	TotalExact bool   `json:"total_exact"`
+	From       int    `json:"from"`
+	Size       int    `json:"size"`
+	NextCursor int    `json:"next_cursor,omitempty"`
+	// Facets are only available without a restricter for the
+	// same reason as Total.
+	Facets *search.Facets `json:"facets,omitempty"`
+	// Suggestions are corrections of the question if it has few hits.
+	Suggestions []string `json:"suggestions,omitempty"`
+	Results     any      `json:"results"`
+}
+
 func (c *controller) autoupdateRequestFromFQIDs(answers map[string]search.Answer) []auRequest {
+	reqFields := c.models.current.Load().reqFields
 	collIdxMap := map[string]int{}
 	var req []auRequest
 	for fqid := range answers {
@@ -54,7 +123,7 @@ func (c *controller) autoupdateRequestFromFQIDs(answers map[string]search.Answer
 			req = append(req, auRequest{
 				Ids:        []int{},
 				Collection: collection,
-				Fields:     c.reqFields[collection],
+				Fields:     reqFields[collection],
 			})
 		}
 
@@ -66,13 +135,14 @@ func (c *controller) autoupdateRequestFromFQIDs(answers map[string]search.Answer
 }
 
 func (c *controller) relatedCollections(req []string) []string {
+	collRel := c.models.current.Load().collRel
 	collMap := map[string]struct{}{}
 	for _, reqColl := range req {
 		if reqColl == "" {
 			continue
 		}
 
-		for coll := range c.collRel[reqColl] {
+		for coll := range collRel[reqColl] {
 			collMap[coll] = struct{}{}
 		}
 	}
@@ -84,6 +154,46 @@ func (c *controller) relatedCollections(req []string) []string {
 	return collections
 }
 
+// intFormValue parses an optional non-negative integer form value.
+func intFormValue(r *http.Request, key string, def int) (int, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, invalidRequestError{
+			fmt.Errorf("'%s' parameter has to be a non-negative integer", key)}
+	}
+	return i, nil
+}
+
+// boolFormValue parses an optional boolean form value.
+func boolFormValue(r *http.Request, key string) (bool, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, invalidRequestError{
+			fmt.Errorf("'%s' parameter has to be a boolean", key)}
+	}
+	return b, nil
+}
+
+// search answers queries against the text index. Besides the query 'q'
+// it accepts a comma separated list of collections 'c', a meeting 'm',
+// the paging parameters 'from' and 'size', 'facets' to request the
+// numbers of hits per collection and meeting and a comma separated list
+// of fields to 'sort' by. With 'format=list' the results are returned
+// as an array in rank order instead of an object keyed by fqid. As the
+// restricter may hide hits from the user, a page can be continued with
+// the returned 'next_cursor' passed as 'cursor'. Questions with few hits
+// are answered with spelling 'suggestions' of words from fields visible
+// to the user. The 'total' number of hits is exact without a restricter.
+// With a restricter it is a lower bound unless 'total_exact' is set, and
+// facets can only be requested without one as they count hidden hits.
 func (c *controller) search(w http.ResponseWriter, r *http.Request) {
 
 	query := r.FormValue("q")
@@ -94,83 +204,319 @@ func (c *controller) search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collections := c.relatedCollections(strings.Split(r.FormValue("c"), ","))
+	from, err := intFormValue(r, "from", 0)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	from, err = intFormValue(r, "cursor", from)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	size, err := intFormValue(r, "size", defaultPageSize)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	if size > maxPageSize {
+		handleErrorWithStatus(w,
+			invalidRequestError{
+				fmt.Errorf("'size' parameter exceeds maximum of %d", maxPageSize)})
+		return
+	}
 
-	meeting, _ := strconv.Atoi(r.FormValue("m"))
-	answers, err := c.qs.Query(query, collections, meeting)
+	facets, err := boolFormValue(r, "facets")
 	if err != nil {
 		handleErrorWithStatus(w, err)
 		return
 	}
 
-	if c.cfg.Restricter.URL != "" {
+	asList := false
+	switch format := r.FormValue("format"); format {
+	case "", "map":
+	case "list":
+		asList = true
+	default:
+		handleErrorWithStatus(w,
+			invalidRequestError{
+				fmt.Errorf("unknown format %q", format)})
+		return
+	}
 
-		userID := c.auth.FromContext(r.Context())
+	var sort []string
+	if v := r.FormValue("sort"); v != "" {
+		sort = strings.Split(v, ",")
+	}
 
-		requestBody := c.autoupdateRequestFromFQIDs(answers)
-		if len(requestBody) == 0 {
-			if _, err := w.Write([]byte("{}")); err != nil {
-				log.Errorf("error: writing response failed: %v\n", err)
-			}
-			return
-		}
+	collections := c.relatedCollections(strings.Split(r.FormValue("c"), ","))
 
-		body, err := json.Marshal(&requestBody)
-		if err != nil {
-			handleErrorWithStatus(w, err)
+	meeting, _ := strconv.Atoi(r.FormValue("m"))
+	req := &search.Request{
+		Question:    query,
+		Collections: collections,
+		MeetingID:   meeting,
+		From:        from,
+		Size:        size,
+		Facets:      facets,
+		Sort:        sort,
+	}
+
+	if c.cfg.Restricter.URL != "" {
+		// Counts of unrestricted hits would reveal hidden documents.
+		if facets {
+			handleErrorWithStatus(w,
+				invalidRequestError{
+					errors.New("'facets' parameter is not supported with a restricter")})
 			return
 		}
+		userID := c.auth.FromContext(r.Context())
 
-		urlParams := fmt.Sprintf("?user_id=%d&single=1", userID)
-		req, err := http.NewRequest("POST", c.cfg.Restricter.URL+urlParams, bytes.NewReader(body))
+		response, err := c.restrictedPage(r.Context(), userID, req, asList)
 		if err != nil {
 			handleErrorWithStatus(w, err)
 			return
 		}
+		writeJSON(w, response)
+		return
+	}
+
+	// No restricter configured.
 
-		req.Header = http.Header{
-			"Content-Type": {"application/json"},
+	result, err := c.qs.Query(r.Context(), req)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+
+	response := searchResponse{
+		Total:       result.Total,
+		TotalExact:  true,
+		From:        from,
+		Size:        size,
+		Facets:      result.Facets,
+		Suggestions: result.Suggestions(nil),
+		Results:     result.Answers,
+	}
+	if next := from + len(result.FQIDs); uint64(next) < result.Total {
+		response.NextCursor = next
+	}
+	if asList {
+		list := make([]listEntry, 0, len(result.FQIDs))
+		for _, fqid := range result.FQIDs {
+			answer := result.Answers[fqid]
+			list = append(list, listEntry{
+				FQID: fqid,
+				resultEntry: resultEntry{
+					MatchedWords: answer.MatchedWords,
+					Snippets:     answer.Snippets,
+					Score:        &answer.Score,
+				},
+			})
 		}
+		response.Results = list
+	}
+	writeJSON(w, &response)
+}
+
+// suggest completes the last word of the prefix 'q' with terms of the
+// fields marked for autocompletion which are visible to the user. It
+// accepts a meeting 'm' and the maximum number of completions 'limit'.
+func (c *controller) suggest(w http.ResponseWriter, r *http.Request) {
+	prefix := r.FormValue("q")
+	if prefix == "" {
+		handleErrorWithStatus(w,
+			invalidRequestError{
+				errors.New("'q' parameter missing")})
+		return
+	}
+
+	limit, err := intFormValue(r, "limit", defaultCompletions)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	if limit > maxCompletions {
+		handleErrorWithStatus(w,
+			invalidRequestError{
+				fmt.Errorf("'limit' parameter exceeds maximum of %d", maxCompletions)})
+		return
+	}
+
+	meeting, _ := strconv.Atoi(r.FormValue("m"))
+	completions, err := c.qs.Complete(r.Context(), prefix, meeting)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	visible, err := c.visibleTerms(r.Context(), c.auth.FromContext(r.Context()), completions.Sources)
+	if err != nil {
+		handleErrorWithStatus(w, err)
+		return
+	}
+	writeJSON(w, map[string][]string{"completions": completions.List(visible, limit)})
+}
+
+// restrictedPage fetches hits from the text index and filters them with
+// the restricter until the page is filled with results visible to the
+// user or the index is exhausted. If asList is set the results are
+// returned in rank order.
+func (c *controller) restrictedPage(ctx context.Context, userID int, req *search.Request, asList bool) (*searchResponse, error) {
+	results := map[string]resultEntry{}
+	list := []listEntry{}
+	response := &searchResponse{
+		From:    req.From,
+		Size:    req.Size,
+		Results: results,
+	}
 
-		client := http.Client{}
-		resp, err := client.Do(req)
+	var total uint64
+	offset := req.From
+	page := *req
+	for round := 0; round < maxRestrictRounds; round++ {
+		page.From = offset
+		result, err := c.qs.Query(ctx, &page)
 		if err != nil {
-			handleErrorWithStatus(w, err)
-			return
+			return nil, err
 		}
-		if resp.StatusCode != http.StatusOK {
-			handleErrorWithStatus(w,
-				invalidRequestError{
-					fmt.Errorf("restricter call failed: %q (%d)",
-						resp.Status, resp.StatusCode)})
-			return
+		total = result.Total
+		if round == 0 {
+			visible, err := c.visibleTerms(ctx, userID, result.SuggestionSources)
+			if err != nil {
+				return nil, err
+			}
+			response.Suggestions = result.Suggestions(visible)
+		}
+		if len(result.FQIDs) == 0 {
+			break
 		}
-		defer resp.Body.Close()
-		w.Header().Set("Content-Type", "application/json")
 
-		filteredResp, err := transformRestricterResponse(answers, resp.Body)
+		filtered, err := c.restrict(ctx, userID, result.Answers)
 		if err != nil {
-			handleErrorWithStatus(w, err)
-			return
+			return nil, err
 		}
 
-		if _, err := w.Write(filteredResp); err != nil {
-			log.Errorf("error: writing response failed: %v\n", err)
+		for _, fqid := range result.FQIDs {
+			if len(results) >= req.Size {
+				break
+			}
+			offset++
+			if entry, ok := filtered[fqid]; ok {
+				results[fqid] = entry
+				list = append(list, listEntry{FQID: fqid, resultEntry: entry})
+			}
+		}
+
+		if len(results) >= req.Size || uint64(offset) >= result.Total {
+			break
 		}
-		return
 	}
 
-	// No restricter configured.
+	if uint64(offset) < total {
+		response.NextCursor = offset
+	}
+	// Only a search from the start through all hits sees every visible hit.
+	response.Total = uint64(len(results))
+	response.TotalExact = req.From == 0 && uint64(offset) >= total
+	if asList {
+		response.Results = list
+	}
+	return response, nil
+}
+
+// restrict asks the restricter which of the answers the user is allowed
+// to see and returns their content.
+func (c *controller) restrict(ctx context.Context, userID int, answers map[string]search.Answer) (result map[string]resultEntry, err error) {
+	requestBody := c.autoupdateRequestFromFQIDs(answers)
+	if len(requestBody) == 0 {
+		return map[string]resultEntry{}, nil
+	}
+
+	body, err := json.Marshal(&requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	urlParams := fmt.Sprintf("?user_id=%d&single=1", userID)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.Restricter.URL+urlParams, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = http.Header{
+		"Content-Type": {"application/json"},
+	}
+
+	start := time.Now()
+	defer func() {
+		c.restricterDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			c.restricterErrors.Inc()
+		}
+	}()
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, invalidRequestError{
+			fmt.Errorf("restricter call failed: %q (%d)",
+				resp.Status, resp.StatusCode)}
+	}
+
+	return transformRestricterResponse(answers, resp.Body)
+}
+
+// visibleTerms tells which of the terms proposed to the user stem from
+// fields of the sources the user is allowed to see. Without a restricter
+// all terms are visible.
+func (c *controller) visibleTerms(ctx context.Context, userID int, sources search.TermSources) (func(string) bool, error) {
+	if c.cfg.Restricter.URL == "" {
+		return nil, nil
+	}
+	filtered, err := c.restrict(ctx, userID, sources)
+	if err != nil {
+		return nil, err
+	}
+	visible := map[string]bool{}
+	for _, entry := range filtered {
+		for _, terms := range entry.MatchedWords {
+			for _, term := range terms {
+				visible[term] = true
+			}
+		}
+	}
+	return func(term string) bool { return visible[term] }, nil
+}
 
+// writeJSON writes the given value as JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if err := json.NewEncoder(w).Encode(answers); err != nil {
-		log.Errorf("error: %v\n", err)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("error: writing response failed: %v\n", err)
 	}
 }
 
+// resultEntry is the restricted content of a search hit.
+type resultEntry struct {
+	Content      map[string]any      `json:"content,omitempty"`
+	MatchedWords map[string][]string `json:"matched_by,omitempty"`
+	Snippets     map[string][]string `json:"snippets,omitempty"`
+	Score        *float64            `json:"score,omitempty"`
+}
+
+// listEntry is a search hit in the ordered list format.
+type listEntry struct {
+	FQID string `json:"fqid"`
+	resultEntry
+}
+
 // transforms the autoupdate response to per fqid objects
-func transformRestricterResponse(answers map[string]search.Answer, body io.ReadCloser) ([]byte, error) {
+func transformRestricterResponse(answers map[string]search.Answer, body io.ReadCloser) (map[string]resultEntry, error) {
 	respBody, err := io.ReadAll(body)
 	if err != nil {
 		return nil, err
@@ -181,11 +527,6 @@ func transformRestricterResponse(answers map[string]search.Answer, body io.ReadC
 		return nil, err
 	}
 
-	type resultEntry struct {
-		Content      map[string]any      `json:"content"`
-		MatchedWords map[string][]string `json:"matched_by,omitempty"`
-		Score        *float64            `json:"score,omitempty"`
-	}
 	transformed := make(map[string]resultEntry)
 	for k, v := range restricterResponse {
 		parts := strings.Split(k, "/")
@@ -195,14 +536,16 @@ func transformRestricterResponse(answers map[string]search.Answer, body io.ReadC
 
 			if _, ok := transformed[fqid]; !ok {
 				var score *float64
-				var matchedWords map[string][]string
+				var matchedWords, snippets map[string][]string
 				if val, ok := answers[fqid]; ok {
 					score = &val.Score
 					matchedWords = val.MatchedWords
+					snippets = val.Snippets
 				}
 				transformed[fqid] = resultEntry{
 					Content:      make(map[string]any),
 					MatchedWords: matchedWords,
+					Snippets:     snippets,
 					Score:        score,
 				}
 			}
@@ -211,12 +554,35 @@ func transformRestricterResponse(answers map[string]search.Answer, body io.ReadC
 		}
 	}
 
-	transformedContent, err := json.Marshal(transformed)
-	if err != nil {
-		return nil, err
+	// Matches in fields the restricter removed must not be revealed.
+	for fqid, entry := range transformed {
+		entry.MatchedWords = visibleFields(entry.MatchedWords, entry.Content)
+		entry.Snippets = visibleFields(entry.Snippets, entry.Content)
+		transformed[fqid] = entry
 	}
 
-	return transformedContent, nil
+	return transformed, nil
+}
+
+// visibleFields returns the entries of the fields contained in content.
+// The index field _<field>_original belongs to <field>.
+func visibleFields(entries map[string][]string, content map[string]any) map[string][]string {
+	if entries == nil {
+		return nil
+	}
+	visible := make(map[string][]string, len(entries))
+	for field, v := range entries {
+		base := field
+		if name, ok := strings.CutPrefix(field, "_"); ok {
+			if name, ok = strings.CutSuffix(name, "_original"); ok {
+				base = name
+			}
+		}
+		if _, ok := content[base]; ok {
+			visible[field] = v
+		}
+	}
+	return visible
 }
 
 func authMiddleware(next http.Handler, auth *auth.Auth) http.Handler {
@@ -282,9 +648,25 @@ func handleError(w http.ResponseWriter, err error, writeStatusCode bool, interna
 	var errClient ClientError
 	if errors.As(err, &errClient) {
 		if writeStatusCode {
+			var retryAfter interface{ RetryAfter() time.Duration }
+			if errors.As(err, &retryAfter) {
+				seconds := int(math.Ceil(retryAfter.RetryAfter().Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(max(1, seconds)))
+			}
 			w.WriteHeader(status)
 		}
 
+		var details interface{ Details() map[string]any }
+		if errors.As(err, &details) {
+			fields := details.Details()
+			fields["type"] = errClient.Type()
+			fields["msg"] = errClient.Error()
+			if err := json.NewEncoder(w).Encode(map[string]any{"error": fields}); err != nil {
+				log.Errorf("error: writing response failed: %v\n", err)
+			}
+			return
+		}
+
 		fmt.Fprintf(w, `{"error": {"type": "%s", "msg": "%s"}}`,
 			errClient.Type(), quote(errClient.Error()))
 		return
@@ -310,22 +692,16 @@ func quote(s string) string {
 }
 
 // Run starts the web server and routes the incoming requests to the controller.
+// The metrics are served on their own port, which should not be public.
 func Run(
 	ctx context.Context,
 	cfg *config.Config,
 	auth *auth.Auth,
 	qs *search.QueryServer,
-	reqFields map[string]map[string]*meta.CollectionRelation,
-	collRel map[string]map[string]struct{},
+	models *Models,
 ) error {
 
-	c := controller{
-		cfg:       cfg,
-		auth:      auth,
-		qs:        qs,
-		reqFields: reqFields,
-		collRel:   collRel,
-	}
+	c := newController(cfg, auth, qs, models)
 
 	mux := http.NewServeMux()
 
@@ -333,12 +709,86 @@ func Run(
 		"/system/search",
 		authMiddleware(http.HandlerFunc(c.search), auth))
 
-	addr := fmt.Sprintf("%s:%d", cfg.Web.Host, cfg.Web.Port)
-	log.Infof("listen web on %s\n", addr)
+	mux.Handle(
+		"/system/search/suggest",
+		authMiddleware(http.HandlerFunc(c.suggest), auth))
+
+	admin := func(h http.HandlerFunc) http.Handler {
+		return authMiddleware(c.adminMiddleware(h), auth)
+	}
+	mux.Handle("/system/search/admin/status", admin(c.status))
+	mux.Handle("/system/search/admin/update", admin(c.update))
+	mux.Handle("/system/search/admin/rebuild", admin(c.rebuild))
+
+	mux.HandleFunc("/system/search/health", c.health)
+	mux.HandleFunc("/system/search/ready", c.ready)
+
+	if cfg.Web.MetricsPort > 0 {
+		reg := prometheus.NewRegistry()
+		if err := c.registerMetrics(reg); err != nil {
+			return fmt.Errorf("registering metrics failed: %w", err)
+		}
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+		go func() {
+			addr := fmt.Sprintf("%s:%d", cfg.Web.Host, cfg.Web.MetricsPort)
+			if err := serve(ctx, "metrics", addr, metricsMux); err != nil {
+				log.Errorf("metrics %v\n", err)
+			}
+		}()
+	}
+
+	return serve(ctx, "web", fmt.Sprintf("%s:%d", cfg.Web.Host, cfg.Web.Port), mux)
+}
+
+// newController creates a controller with its metrics.
+func newController(
+	cfg *config.Config,
+	auth *auth.Auth,
+	qs *search.QueryServer,
+	models *Models,
+) *controller {
+	return &controller{
+		cfg:    cfg,
+		auth:   auth,
+		qs:     qs,
+		models: models,
+		restricterDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "search_restricter_duration_seconds",
+			Help:    "Time needed by calls to the restricter.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		restricterErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "search_restricter_errors_total",
+			Help: "Number of failed calls to the restricter.",
+		}),
+	}
+}
+
+// registerMetrics registers the metrics of the controller and its
+// query server together with those of the Go runtime.
+func (c *controller) registerMetrics(reg prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		c.restricterDuration,
+		c.restricterErrors,
+	} {
+		if err := reg.Register(collector); err != nil {
+			return err
+		}
+	}
+	return c.qs.RegisterMetrics(reg)
+}
+
+// serve runs a http server until the context is done.
+func serve(ctx context.Context, name string, addr string, handler http.Handler) error {
+	log.Infof("listen %s on %s\n", name, addr)
 
 	s := &http.Server{
 		Addr:        addr,
-		Handler:     mux,
+		Handler:     handler,
 		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
@@ -349,7 +799,7 @@ func Run(
 			done <- fmt.Errorf("server error: %v", err)
 			return
 		}
-		log.Infof("web server done")
+		log.Infof("%s server done", name)
 		done <- nil
 	}()
 	if err := s.ListenAndServe(); err != http.ErrServerClosed {
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2022 Since 2011 Authors of OpenSlides, see https://github.com/OpenSlides/OpenSlides/blob/master/AUTHORS
+//
+// SPDX-License-Identifier: MIT
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-search-service/pkg/config"
+	"github.com/OpenSlides/openslides-search-service/pkg/meta"
+	"github.com/OpenSlides/openslides-search-service/pkg/search"
+)
+
+func TestTransformRestricterResponseHidesRemovedFields(t *testing.T) {
+	answers := map[string]search.Answer{
+		"motion/1": {
+			Score: 1,
+			MatchedWords: map[string][]string{
+				"title":           {"haushalt"},
+				"_title_original": {"haushalt"},
+				"text":            {"haushalt"},
+			},
+			Snippets: map[string][]string{
+				"title": {"<mark>Haushalt</mark>"},
+				"text":  {"Der geheime <mark>Haushalt</mark>"},
+			},
+		},
+	}
+	// The restricter removed the text of the motion.
+	body := io.NopCloser(strings.NewReader(`{"motion/1/title": "Haushalt"}`))
+
+	transformed, err := transformRestricterResponse(answers, body)
+	if err != nil {
+		t.Fatalf("transforming: %v", err)
+	}
+
+	entry := transformed["motion/1"]
+	wantWords := map[string][]string{
+		"title":           {"haushalt"},
+		"_title_original": {"haushalt"},
+	}
+	if !reflect.DeepEqual(entry.MatchedWords, wantWords) {
+		t.Errorf("matched words = %v, want %v", entry.MatchedWords, wantWords)
+	}
+	wantSnippets := map[string][]string{"title": {"<mark>Haushalt</mark>"}}
+	if !reflect.DeepEqual(entry.Snippets, wantSnippets) {
+		t.Errorf("snippets = %v, want %v", entry.Snippets, wantSnippets)
+	}
+
+	// The cached answer must not be modified.
+	if len(answers["motion/1"].Snippets) != 2 {
+		t.Errorf("answer was modified: %v", answers["motion/1"].Snippets)
+	}
+}
+
+func TestVisibleTerms(t *testing.T) {
+	restricter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("user_id"); got != "7" {
+			t.Errorf("user_id = %q, want 7", got)
+		}
+		// The user can see the title of motion/1 but not motion/2.
+		io.WriteString(w, `{"motion/1/title": "Haushalt", "motion/2/id": 2}`)
+	}))
+	defer restricter.Close()
+
+	cfg := &config.Config{}
+	cfg.Restricter.URL = restricter.URL
+	c := newController(cfg, nil, nil, NewModels(map[string]map[string]*meta.CollectionRelation{
+		"motion": {"id": nil, "title": nil},
+	}, nil))
+	sources := search.TermSources{
+		"motion/1": {MatchedWords: map[string][]string{"_title_original": {"haushalt"}}},
+		"motion/2": {MatchedWords: map[string][]string{"_title_original": {"geheim"}}},
+	}
+
+	visible, err := c.visibleTerms(context.Background(), 7, sources)
+	if err != nil {
+		t.Fatalf("checking terms: %v", err)
+	}
+	for term, want := range map[string]bool{"haushalt": true, "geheim": false, "other": false} {
+		if got := visible(term); got != want {
+			t.Errorf("visible(%q) = %t, want %t", term, got, want)
+		}
+	}
+
+	c.cfg = &config.Config{}
+	if visible, err := c.visibleTerms(context.Background(), 7, sources); err != nil || visible != nil {
+		t.Errorf("without restricter got a filter (%t) or error (%v), want all terms visible", visible != nil, err)
+	}
+}
+
+func TestSearchRejectsFacetsWithRestricter(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Restricter.URL = "http://restricter"
+	c := newController(cfg, nil, nil, NewModels(nil, nil))
+
+	rec := httptest.NewRecorder()
+	c.search(rec, httptest.NewRequest("GET", "/system/search?q=Haushalt&facets=true", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rec.Body.String(), "facets") {
+		t.Errorf("body %q does not name the facets", rec.Body.String())
+	}
+}
+
+// retryError is a client error which asks to retry later.
+type retryError struct {
+	retryAfter time.Duration
+}
+
+func (e retryError) Error() string             { return "try again later" }
+func (e retryError) Type() string              { return "queue_full" }
+func (e retryError) StatusCode() int           { return http.StatusServiceUnavailable }
+func (e retryError) RetryAfter() time.Duration { return e.retryAfter }
+
+func TestHandleErrorRetryAfter(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"overload", search.OverloadError{}, "1"},
+		{"rounded up", retryError{retryAfter: 2500 * time.Millisecond}, "3"},
+		{"wrapped", fmt.Errorf("searching: %w", retryError{retryAfter: 5 * time.Second}), "5"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleErrorWithStatus(rec, tt.err)
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.want {
+				t.Errorf("Retry-After = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), `"type": "queue_full"`) {
+				t.Errorf("body = %s, want the error type queue_full", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnswerFormatsUseTheSameKeys(t *testing.T) {
+	answer := search.Answer{
+		Score:        1.5,
+		MatchedWords: map[string][]string{"title": {"haushalt"}},
+		Snippets:     map[string][]string{"title": {"<mark>Haushalt</mark>"}},
+	}
+	entry := resultEntry{
+		MatchedWords: answer.MatchedWords,
+		Snippets:     answer.Snippets,
+		Score:        &answer.Score,
+	}
+
+	decode := func(v any) map[string]any {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("encoding %T: %v", v, err)
+		}
+		var decoded map[string]any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("decoding %T: %v", v, err)
+		}
+		return decoded
+	}
+
+	if got, want := decode(answer), decode(entry); !reflect.DeepEqual(got, want) {
+		t.Errorf("answer is encoded as %v, list entry as %v", got, want)
+	}
+}